@@ -21,7 +21,14 @@ import (
 
 	_ "github.com/scionproto-contrib/caddy-scion/reverse/advertiser"
 	_ "github.com/scionproto-contrib/caddy-scion/reverse/detector"
+	_ "github.com/scionproto-contrib/caddy-scion/reverse/l4matcher"
+	_ "github.com/scionproto-contrib/caddy-scion/reverse/l4proxy"
+	_ "github.com/scionproto-contrib/caddy-scion/reverse/matcher"
 	_ "github.com/scionproto-contrib/caddy-scion/reverse/native"
+	_ "github.com/scionproto-contrib/caddy-scion/reverse/pathpolicy"
+	_ "github.com/scionproto-contrib/caddy-scion/reverse/placeholders"
+	_ "github.com/scionproto-contrib/caddy-scion/reverse/transport"
+	_ "github.com/scionproto-contrib/caddy-scion/reverse/upstreams"
 )
 
 func main() {
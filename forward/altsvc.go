@@ -0,0 +1,328 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/scionproto-contrib/http-proxy/forward/session"
+	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/altsvc"
+	"github.com/scionproto-contrib/caddy-scion/networks/scionupstream"
+)
+
+// maxAltSvcOrigins bounds the number of origins whose HTTP/3 endpoints are
+// remembered.
+const maxAltSvcOrigins = 1024
+
+// hopByHopHeaders are not forwarded, see RFC 9110, Section 7.6.1.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// h3Upgrader learns the HTTP/3-over-SCION endpoints that origins advertise in
+// their Alt-Svc headers, and sends later requests to these origins over
+// native HTTP/3 instead of through the core proxy. Endpoints are remembered
+// per origin authority, i.e., host:port.
+type h3Upgrader struct {
+	logger      *zap.Logger
+	dialTimeout time.Duration
+	transport   *http3.Transport
+
+	mu        sync.Mutex
+	endpoints map[string]altSvcEntry
+}
+
+type altSvcEntry struct {
+	address string
+	expires time.Time
+}
+
+func newH3Upgrader(logger *zap.Logger, dialTimeout time.Duration) *h3Upgrader {
+	u := &h3Upgrader{
+		logger:      logger,
+		dialTimeout: dialTimeout,
+		endpoints:   make(map[string]altSvcEntry),
+	}
+	u.transport = &http3.Transport{
+		TLSClientConfig: &tls.Config{},
+		Dial:            u.dial,
+	}
+	return u
+}
+
+// upgradable reports whether r can be sent over HTTP/3. Only requests for
+// https origins are upgraded: the alternative is secured with TLS, and an
+// http origin would have to support opportunistic security (RFC 8164) to be
+// reached over it with its scheme kept. Requests tunneled with CONNECT never
+// get here. Only requests without body are upgraded, so that they can be
+// retried through the core proxy.
+func upgradable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return r.URL.Scheme == "https"
+}
+
+// session applies the proxy authorization and session parsing of the core
+// proxy to r. It reports whether the core proxy accepts r, and whether the
+// session of r uses the default path policy. Requests of sessions with a path
+// policy are left to the core proxy, since it applies the policy.
+func (u *h3Upgrader) session(r *http.Request) (authorized, defaultPolicy bool) {
+	cookie, ok := proxyAuthCookie(r)
+	if !ok {
+		return false, false
+	}
+	// The core proxy only reads the session from the cookie passed in the
+	// Proxy-Authorization header.
+	sr := &http.Request{Header: make(http.Header)}
+	if cookie != "" {
+		sr.Header.Set("Cookie", cookie)
+	}
+	sd, err := session.GetSessionData(u.logger, sr)
+	if err != nil {
+		return false, false
+	}
+	return true, len(sd.Policy) == 0
+}
+
+// proxyAuthCookie returns the session cookie that clients of the core proxy
+// pass as the password of the "policy" user in the Proxy-Authorization
+// header. It reports false if the header is missing or malformed, in which
+// case the core proxy rejects the request.
+func proxyAuthCookie(r *http.Request) (string, bool) {
+	const prefix = "Basic "
+	auth := r.Header.Get("Proxy-Authorization")
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	c, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", false
+	}
+	username, cookie, ok := strings.Cut(string(c), ":")
+	if !ok || username != "policy" {
+		return "", false
+	}
+	return cookie, true
+}
+
+// serve sends r over HTTP/3 if the origin advertised an endpoint. It reports
+// whether the response was written; if not, r is to be proxied as usual.
+func (u *h3Upgrader) serve(w http.ResponseWriter, r *http.Request) bool {
+	authority := originAuthority(r)
+	if _, ok := u.lookup(authority); !ok {
+		return false
+	}
+
+	out := r.Clone(r.Context())
+	out.URL.Host = authority
+	out.Host = ""
+	out.RequestURI = ""
+	for _, h := range hopByHopHeaders {
+		out.Header.Del(h)
+	}
+	removeSessionCookie(out)
+
+	resp, err := u.transport.RoundTrip(out)
+	if err != nil {
+		u.logger.Debug("HTTP/3 upgrade failed, falling back to the proxy",
+			zap.String("origin", authority), zap.Error(err))
+		u.forget(authority)
+		return false
+	}
+	defer resp.Body.Close()
+
+	u.learn(authority, resp.Header)
+	for _, h := range hopByHopHeaders {
+		resp.Header.Del(h)
+	}
+	// Same as the responses of the core proxy.
+	w.Header().Del("Server")
+	w.Header().Add("Via", strconv.Itoa(resp.ProtoMajor)+"."+strconv.Itoa(resp.ProtoMinor)+" caddy")
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		u.logger.Debug("copying HTTP/3 response", zap.String("origin", authority), zap.Error(err))
+	}
+	return true
+}
+
+// observe wraps w, so that the Alt-Svc header of the response proxied for r
+// is learned.
+func (u *h3Upgrader) observe(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	return &altSvcObserver{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+		upgrader:              u,
+		authority:             originAuthority(r),
+	}
+}
+
+// learn records the endpoints advertised in the Alt-Svc header of a response
+// of the origin.
+func (u *h3Upgrader) learn(authority string, header http.Header) {
+	values := header.Values(altsvc.Header)
+	if len(values) == 0 {
+		return
+	}
+	endpoints, clear := altsvc.Parse(values)
+	if clear {
+		u.forget(authority)
+		return
+	}
+	if len(endpoints) == 0 {
+		return
+	}
+	// Clients may pick any of the alternatives; the first one is used.
+	e := endpoints[0]
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.endpoints[authority]; !ok && len(u.endpoints) >= maxAltSvcOrigins {
+		for k := range u.endpoints {
+			delete(u.endpoints, k)
+			break
+		}
+	}
+	u.endpoints[authority] = altSvcEntry{
+		address: e.Addr.String(),
+		expires: time.Now().Add(e.MaxAge),
+	}
+	u.logger.Debug("learned HTTP/3 endpoint",
+		zap.String("origin", authority), zap.String("endpoint", e.Addr.String()))
+}
+
+func (u *h3Upgrader) lookup(authority string) (string, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	e, ok := u.endpoints[authority]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(e.expires) {
+		delete(u.endpoints, authority)
+		return "", false
+	}
+	return e.address, true
+}
+
+func (u *h3Upgrader) forget(authority string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.endpoints, authority)
+}
+
+// dial connects to the endpoint advertised by the origin, while the TLS
+// handshake is done for the origin itself.
+func (u *h3Upgrader) dial(
+	ctx context.Context,
+	addr string,
+	tlsCfg *tls.Config,
+	cfg *quic.Config,
+) (*quic.Conn, error) {
+	address, ok := u.lookup(addr)
+	if !ok {
+		return nil, errors.New("no HTTP/3 endpoint known for " + addr)
+	}
+	ctx, cancel := context.WithTimeout(ctx, u.dialTimeout)
+	defer cancel()
+	return scionupstream.DialQUICEarly(ctx, address, tlsCfg, cfg, nil)
+}
+
+func (u *h3Upgrader) close() error {
+	return u.transport.Close()
+}
+
+// originAuthority returns the host:port of the https origin of r.
+func originAuthority(r *http.Request) string {
+	hostPort := r.URL.Host
+	if hostPort == "" {
+		hostPort = r.Host
+	}
+	if _, _, err := net.SplitHostPort(hostPort); err == nil {
+		return hostPort
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(hostPort, "["), "]"), "443")
+}
+
+// removeSessionCookie removes the session cookie of the core proxy, which is
+// not meant for the origin.
+func removeSessionCookie(r *http.Request) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != session.SessionName {
+			r.AddCookie(c)
+		}
+	}
+}
+
+// altSvcObserver learns the Alt-Svc header of the response once it is
+// written.
+type altSvcObserver struct {
+	*caddyhttp.ResponseWriterWrapper
+	upgrader  *h3Upgrader
+	authority string
+	observed  bool
+}
+
+func (w *altSvcObserver) WriteHeader(status int) {
+	if !w.observed && status >= http.StatusOK {
+		w.observed = true
+		w.upgrader.learn(w.authority, w.Header())
+	}
+	w.ResponseWriterWrapper.WriteHeader(status)
+}
+
+func (w *altSvcObserver) Write(b []byte) (int, error) {
+	if !w.observed {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriterWrapper.Write(b)
+}
+
+// ReadFrom implements io.ReaderFrom. Without it, the ReadFrom of the wrapper
+// would be promoted and write the response without observing it.
+func (w *altSvcObserver) ReadFrom(r io.Reader) (int64, error) {
+	if !w.observed {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriterWrapper.ReadFrom(r)
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forward
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scionproto-contrib/http-proxy/forward/session"
+	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/altsvc"
+)
+
+const testAltSvc = `h3-scion="[1-ff00:0:110,192.0.2.1]:8443"; ma=60`
+
+func proxyAuth(userPass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(userPass))
+}
+
+// sessionCookie returns a session cookie of the core proxy with the given
+// path policy.
+func sessionCookie(t *testing.T, policy string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	sd := session.SessionData{ID: "test", Policy: []byte(policy)}
+	if err := session.SetSessionData(zap.NewNop(), rec, req, sd); err != nil {
+		t.Fatal(err)
+	}
+	c := rec.Result().Cookies()[0]
+	return c.Name + "=" + c.Value
+}
+
+func TestSession(t *testing.T) {
+	tests := map[string]struct {
+		auth                      string
+		authorized, defaultPolicy bool
+	}{
+		"missing":         {auth: ""},
+		"not basic":       {auth: "Bearer token"},
+		"not base64":      {auth: "Basic !!!"},
+		"other user":      {auth: proxyAuth("user:pass")},
+		"no session":      {auth: proxyAuth("policy:"), authorized: true, defaultPolicy: true},
+		"lowercase basic": {auth: "basic " + base64.StdEncoding.EncodeToString([]byte("policy:")), authorized: true, defaultPolicy: true},
+		"default policy":  {auth: proxyAuth("policy:" + sessionCookie(t, "")), authorized: true, defaultPolicy: true},
+		"path policy":     {auth: proxyAuth("policy:" + sessionCookie(t, `{"sequence":"0*"}`)), authorized: true},
+	}
+	u := newH3Upgrader(zap.NewNop(), time.Second)
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			if tc.auth != "" {
+				r.Header.Set("Proxy-Authorization", tc.auth)
+			}
+			authorized, defaultPolicy := u.session(r)
+			if authorized != tc.authorized || defaultPolicy != tc.defaultPolicy {
+				t.Errorf("got %v, %v, want %v, %v", authorized, defaultPolicy, tc.authorized, tc.defaultPolicy)
+			}
+		})
+	}
+}
+
+func TestUpgradable(t *testing.T) {
+	tests := []struct {
+		method, target string
+		want           bool
+	}{
+		{http.MethodGet, "https://example.com/", true},
+		{http.MethodHead, "https://example.com:8443/", true},
+		{http.MethodGet, "http://example.com/", false},
+		{http.MethodPost, "https://example.com/", false},
+		{http.MethodConnect, "example.com:443", false},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(tc.method, tc.target, nil)
+		if got := upgradable(r); got != tc.want {
+			t.Errorf("%s %s: got %v, want %v", tc.method, tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestOriginAuthority(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/":      "example.com:443",
+		"https://example.com:8443/": "example.com:8443",
+		"https://[::1]/":            "[::1]:443",
+		"https://[::1]:8443/":       "[::1]:8443",
+	}
+	for target, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if got := originAuthority(r); got != want {
+			t.Errorf("%s: got %s, want %s", target, got, want)
+		}
+	}
+}
+
+func TestObserveLearnsPerOrigin(t *testing.T) {
+	u := newH3Upgrader(zap.NewNop(), time.Second)
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+
+	// The response is written through ReadFrom.
+	w := u.observe(httptest.NewRecorder(), r)
+	w.Header().Set(altsvc.Header, testAltSvc)
+	if _, err := io.Copy(w, struct{ io.Reader }{strings.NewReader("body")}); err != nil {
+		t.Fatal(err)
+	}
+	if addr, ok := u.lookup("example.com:443"); !ok || addr != "[1-ff00:0:110,192.0.2.1]:8443" {
+		t.Fatalf("got %q, %v", addr, ok)
+	}
+	if _, ok := u.lookup("example.com:8443"); ok {
+		t.Fatal("endpoint learned for another port of the origin")
+	}
+
+	// The origin invalidates its alternatives.
+	w = u.observe(httptest.NewRecorder(), r)
+	w.Header().Set(altsvc.Header, "clear")
+	w.WriteHeader(http.StatusOK)
+	if _, ok := u.lookup("example.com:443"); ok {
+		t.Fatal("endpoint not forgotten after clear")
+	}
+}
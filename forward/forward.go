@@ -19,6 +19,8 @@ import (
 	"time"
 
 	caddy "github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"go.uber.org/zap"
 
@@ -41,10 +43,13 @@ var (
 	_ caddy.Provisioner           = (*Handler)(nil)
 	_ caddy.CleanerUpper          = (*Handler)(nil)
 	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
+	_ caddyfile.Unmarshaler       = (*Handler)(nil)
 )
 
 func init() {
 	caddy.RegisterModule(Handler{})
+	httpcaddyfile.RegisterHandlerDirective("forward_proxy", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("forward_proxy", httpcaddyfile.Before, "reverse_proxy")
 }
 
 // ResolveHandler defines an interface for handling HTTP requests related to
@@ -90,7 +95,15 @@ type Handler struct {
 	// Default: 1m
 	PurgeInterval caddy.Duration `json:"purge_interval,omitempty"`
 
-	coreProxy *forward.CoreProxy
+	// Whether to disable sending requests for https origins over native
+	// HTTP/3 to origins that advertise an HTTP/3-over-SCION endpoint in their
+	// Alt-Svc header. Only requests the proxy forwards itself are upgraded,
+	// not CONNECT tunnels, and never those of sessions with a path policy.
+	// Default: false
+	DisableH3Upgrade bool `json:"disable_h3_upgrade,omitempty"`
+
+	coreProxy  *forward.CoreProxy
+	h3Upgrader *h3Upgrader
 }
 
 // CaddyModule returns the Caddy module information.
@@ -121,12 +134,19 @@ func (h *Handler) Provision(ctx caddy.Context) error {
 		h.PurgeInterval = caddy.Duration(1 * time.Minute)
 	}
 
+	if !h.DisableH3Upgrade {
+		h.h3Upgrader = newH3Upgrader(h.logger.Named("h3_upgrade"), time.Duration(h.DialTimeout))
+	}
+
 	h.coreProxy = forward.NewCoreProxy(h.logger, time.Duration(h.ResolveTimeout), time.Duration(h.DialTimeout), time.Duration(h.PurgeTimeout), time.Duration(h.PurgeInterval), h.DisablePurgeInactiveDialers)
 	return h.coreProxy.Initialize()
 }
 
 // Cleanup cleans up the handler.
 func (h *Handler) Cleanup() error {
+	if h.h3Upgrader != nil {
+		h.h3Upgrader.close()
+	}
 	return h.coreProxy.Cleanup()
 }
 
@@ -159,12 +179,102 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhtt
 		}
 		return nil
 	}
+	// Only requests the core proxy accepts are upgraded or learned from.
+	if h.h3Upgrader != nil && upgradable(r) {
+		if authorized, defaultPolicy := h.h3Upgrader.session(r); authorized {
+			if defaultPolicy && h.h3Upgrader.serve(w, r) {
+				return nil
+			}
+			w = h.h3Upgrader.observe(w, r)
+		}
+	}
 	if err := h.coreProxy.HandleTunnelRequest(w, r); err != nil {
 		return caddyError(err)
 	}
 	return nil
 }
 
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+//	forward_proxy [<matcher>] {
+//		hosts                          <hosts...>
+//		resolve_timeout                <duration>
+//		dial_timeout                   <duration>
+//		disable_purge_inactive_dialers
+//		purge_timeout                  <duration>
+//		purge_interval                 <duration>
+//		disable_h3_upgrade
+//	}
+//
+// The hosts subdirective can be repeated.
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "hosts":
+			hosts := d.RemainingArgs()
+			if len(hosts) == 0 {
+				return d.ArgErr()
+			}
+			h.Hosts = append(h.Hosts, hosts...)
+		case "resolve_timeout":
+			if err := parseDuration(d, &h.ResolveTimeout); err != nil {
+				return err
+			}
+		case "dial_timeout":
+			if err := parseDuration(d, &h.DialTimeout); err != nil {
+				return err
+			}
+		case "disable_purge_inactive_dialers":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.DisablePurgeInactiveDialers = true
+		case "disable_h3_upgrade":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.DisableH3Upgrade = true
+		case "purge_timeout":
+			if err := parseDuration(d, &h.PurgeTimeout); err != nil {
+				return err
+			}
+		case "purge_interval":
+			if err := parseDuration(d, &h.PurgeInterval); err != nil {
+				return err
+			}
+		default:
+			return d.Errf("unrecognized subdirective: %s", d.Val())
+		}
+	}
+	return nil
+}
+
+func parseDuration(d *caddyfile.Dispenser, dur *caddy.Duration) error {
+	name := d.Val()
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	v, err := caddy.ParseDuration(d.Val())
+	if err != nil {
+		return d.Errf("parsing %s: %v", name, err)
+	}
+	*dur = caddy.Duration(v)
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	fp := new(Handler)
+	err := fp.UnmarshalCaddyfile(h.Dispenser)
+	return fp, err
+}
+
 func caddyError(err error) error {
 	if he, ok := err.(*utils.HandlerError); ok {
 		return caddyhttp.Error(he.StatusCode, he.Err)
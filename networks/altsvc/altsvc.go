@@ -0,0 +1,120 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package altsvc formats and parses the Alt-Svc entries (RFC 7838) that
+// advertise HTTP/3 endpoints reachable over SCION, e.g.,
+//
+//	Alt-Svc: h3-scion="[1-ff00:0:110,192.0.2.1]:8443"; ma=86400
+//
+// Clients that do not know the h3-scion protocol ID ignore the entries, so
+// they can share the header with the regular h3 ones.
+package altsvc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+const (
+	// Header is the name of the header carrying the entries.
+	Header = "Alt-Svc"
+	// ProtocolID identifies HTTP/3 over SCION.
+	ProtocolID = "h3-scion"
+	// DefaultMaxAge is the freshness of entries without ma parameter.
+	DefaultMaxAge = 24 * time.Hour
+)
+
+// Endpoint is an advertised HTTP/3 endpoint.
+type Endpoint struct {
+	// Addr is the SCION address of the endpoint.
+	Addr *snet.UDPAddr
+	// MaxAge is how long the advertisement is fresh.
+	MaxAge time.Duration
+}
+
+// Format returns the Alt-Svc value advertising the given endpoints.
+func Format(endpoints []*snet.UDPAddr, maxAge time.Duration) string {
+	entries := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		entries = append(entries, fmt.Sprintf("%s=%q; ma=%d",
+			ProtocolID, e.String(), int64(maxAge/time.Second)))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// Parse extracts the h3-scion endpoints from the values of the Alt-Svc
+// header. Entries of other protocols and malformed entries are skipped. clear
+// reports whether the origin invalidated all its alternatives.
+func Parse(values []string) (endpoints []Endpoint, clear bool) {
+	for _, v := range values {
+		for _, entry := range split(v, ',') {
+			if strings.TrimSpace(entry) == "clear" {
+				return nil, true
+			}
+			params := split(entry, ';')
+			proto, authority, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+			if !ok || strings.TrimSpace(proto) != ProtocolID {
+				continue
+			}
+			addr, err := snet.ParseUDPAddr(unquote(strings.TrimSpace(authority)))
+			if err != nil || addr.Host.Port == 0 {
+				continue
+			}
+			e := Endpoint{Addr: addr, MaxAge: DefaultMaxAge}
+			for _, p := range params[1:] {
+				k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if strings.TrimSpace(k) != "ma" {
+					continue
+				}
+				if ma, err := strconv.ParseUint(unquote(strings.TrimSpace(v)), 10, 32); err == nil {
+					e.MaxAge = time.Duration(ma) * time.Second
+				}
+			}
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints, false
+}
+
+// split splits s at sep, ignoring separators within quoted strings.
+func split(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquote(s string) string {
+	if u, err := strconv.Unquote(s); err == nil && strings.HasPrefix(s, `"`) {
+		return u
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package altsvc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+func mustAddr(t *testing.T, s string) *snet.UDPAddr {
+	t.Helper()
+	a, err := snet.ParseUDPAddr(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestFormat(t *testing.T) {
+	endpoints := []*snet.UDPAddr{
+		mustAddr(t, "1-ff00:0:110,192.0.2.1:8443"),
+		mustAddr(t, "1-ff00:0:111,[fd00::1]:443"),
+	}
+	got := Format(endpoints, 90*time.Minute)
+	want := `h3-scion="[1-ff00:0:110,192.0.2.1]:8443"; ma=5400, ` +
+		`h3-scion="[1-ff00:0:111,fd00::1]:443"; ma=5400`
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	// Parsing the formatted value yields the endpoints again.
+	parsed, clear := Parse([]string{got})
+	if clear || len(parsed) != len(endpoints) {
+		t.Fatalf("parsed %v, clear %v", parsed, clear)
+	}
+	for i, e := range parsed {
+		if e.Addr.String() != endpoints[i].String() || e.MaxAge != 90*time.Minute {
+			t.Errorf("endpoint %d: got %s, ma %s", i, e.Addr, e.MaxAge)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	type endpoint struct {
+		addr   string
+		maxAge time.Duration
+	}
+	tests := []struct {
+		name   string
+		values []string
+		want   []endpoint
+		clear  bool
+	}{
+		{
+			name:   "default max age",
+			values: []string{`h3-scion="1-ff00:0:110,192.0.2.1:8443"`},
+			want:   []endpoint{{"[1-ff00:0:110,192.0.2.1]:8443", DefaultMaxAge}},
+		},
+		{
+			name:   "max age",
+			values: []string{`h3-scion="1-ff00:0:110,192.0.2.1:8443"; ma=60`},
+			want:   []endpoint{{"[1-ff00:0:110,192.0.2.1]:8443", time.Minute}},
+		},
+		{
+			name:   "quoted max age and other parameters",
+			values: []string{`h3-scion="1-ff00:0:110,192.0.2.1:8443";persist=1 ; ma="120"`},
+			want:   []endpoint{{"[1-ff00:0:110,192.0.2.1]:8443", 2 * time.Minute}},
+		},
+		{
+			name:   "invalid max age",
+			values: []string{`h3-scion="1-ff00:0:110,192.0.2.1:8443"; ma=-1`},
+			want:   []endpoint{{"[1-ff00:0:110,192.0.2.1]:8443", DefaultMaxAge}},
+		},
+		{
+			name: "quoted commas and semicolons",
+			values: []string{`h3=":443"; foo="a,b;c", ` +
+				`h3-scion="1-ff00:0:110,[fd00::1]:443"; ma=30`},
+			want: []endpoint{{"[1-ff00:0:110,fd00::1]:443", 30 * time.Second}},
+		},
+		{
+			name:   "escaped quote",
+			values: []string{`h2="x\",y"; ma=1, h3-scion="1-ff00:0:110,192.0.2.1:8443"`},
+			want:   []endpoint{{"[1-ff00:0:110,192.0.2.1]:8443", DefaultMaxAge}},
+		},
+		{
+			name: "unknown protocol IDs",
+			values: []string{
+				`h3=":443"; ma=86400, h3-29=":443"`,
+				`h3-scion-draft="1-ff00:0:110,192.0.2.1:8443"`,
+			},
+		},
+		{
+			name:   "several values",
+			values: []string{`h3=":443"`, `h3-scion="1-ff00:0:110,192.0.2.1:1", h3-scion="1-ff00:0:111,192.0.2.2:2"`},
+			want: []endpoint{
+				{"[1-ff00:0:110,192.0.2.1]:1", DefaultMaxAge},
+				{"[1-ff00:0:111,192.0.2.2]:2", DefaultMaxAge},
+			},
+		},
+		{
+			name: "malformed entries",
+			values: []string{`h3-scion, h3-scion="192.0.2.1:8443", h3-scion="1-ff00:0:110,192.0.2.1"` +
+				`, h3-scion="1-ff00:0:110,192.0.2.1:8443`},
+		},
+		{
+			name:   "clear",
+			values: []string{`clear`},
+			clear:  true,
+		},
+		{
+			name:   "clear with other entries",
+			values: []string{`h3-scion="1-ff00:0:110,192.0.2.1:8443"`, ` clear `},
+			clear:  true,
+		},
+		{
+			name:   "quoted clear",
+			values: []string{`h3=":443"; foo="clear"`},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, clear := Parse(tc.values)
+			if clear != tc.clear {
+				t.Fatalf("got clear %v, want %v", clear, tc.clear)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d endpoints, want %d: %v", len(got), len(tc.want), got)
+			}
+			for i, e := range got {
+				if e.Addr.String() != tc.want[i].addr || e.MaxAge != tc.want[i].maxAge {
+					t.Errorf("endpoint %d: got %s, ma %s, want %s, ma %s",
+						i, e.Addr, e.MaxAge, tc.want[i].addr, tc.want[i].maxAge)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package connmetrics provides the metrics of the SCION packet connections,
+// labelled per listener.
+//
+// The collectors outlive Caddy config loads, because the listeners are reused
+// across them. On every load they are registered with the metrics registry of
+// the new Caddy context.
+package connmetrics
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+var listenerLabels = []string{"network", "isd_as", "host", "port"}
+
+// PacketConnMetrics holds the collectors of the SCION packet connection
+// metrics.
+type PacketConnMetrics struct {
+	closes         *prometheus.CounterVec
+	readBytes      *prometheus.CounterVec
+	readPackets    *prometheus.CounterVec
+	writeBytes     *prometheus.CounterVec
+	writePackets   *prometheus.CounterVec
+	parseErrors    *prometheus.CounterVec
+	scmpErrors     *prometheus.CounterVec
+	underlayErrors *prometheus.CounterVec
+}
+
+// NewPacketConnMetrics creates the collectors. They are not registered.
+func NewPacketConnMetrics() *PacketConnMetrics {
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "caddy",
+			Subsystem: "scion",
+			Name:      name,
+			Help:      help,
+		}, listenerLabels)
+	}
+	return &PacketConnMetrics{
+		closes:         counter("conn_closes_total", "Total number of Close calls."),
+		readBytes:      counter("conn_read_bytes_total", "Total number of bytes read."),
+		readPackets:    counter("conn_read_packets_total", "Total number of packets read."),
+		writeBytes:     counter("conn_write_bytes_total", "Total number of bytes written."),
+		writePackets:   counter("conn_write_packets_total", "Total number of packets written."),
+		parseErrors:    counter("conn_parse_errors_total", "Total number of parse errors."),
+		scmpErrors:     counter("conn_scmp_errors_total", "Total number of SCMP errors."),
+		underlayErrors: counter("conn_underlay_errors_total", "Total number of underlay connection errors."),
+	}
+}
+
+// Collectors returns the collectors to be registered.
+func (m *PacketConnMetrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.closes,
+		m.readBytes,
+		m.readPackets,
+		m.writeBytes,
+		m.writePackets,
+		m.parseErrors,
+		m.scmpErrors,
+		m.underlayErrors,
+	}
+}
+
+// ForListener returns the metrics of the listener of network on laddr. It
+// returns empty metrics if m is nil.
+func (m *PacketConnMetrics) ForListener(network string, laddr *snet.UDPAddr) snet.SCIONPacketConnMetrics {
+	if m == nil {
+		return snet.SCIONPacketConnMetrics{}
+	}
+	return m.with(prometheus.Labels{
+		"network": network,
+		"isd_as":  laddr.IA.String(),
+		"host":    laddr.Host.IP.String(),
+		"port":    strconv.Itoa(laddr.Host.Port),
+	})
+}
+
+func (m *PacketConnMetrics) with(labels prometheus.Labels) snet.SCIONPacketConnMetrics {
+	return snet.SCIONPacketConnMetrics{
+		Closes:                   m.closes.With(labels),
+		ReadBytes:                m.readBytes.With(labels),
+		ReadPackets:              m.readPackets.With(labels),
+		WriteBytes:               m.writeBytes.With(labels),
+		WritePackets:             m.writePackets.With(labels),
+		ParseErrors:              m.parseErrors.With(labels),
+		SCMPErrors:               m.scmpErrors.With(labels),
+		UnderlayConnectionErrors: m.underlayErrors.With(labels),
+	}
+}
+
+// Register registers the collectors with reg. Collectors that are already
+// registered are skipped, so it is safe to call it on every config load.
+func Register(reg prometheus.Registerer, collectors ...prometheus.Collector) error {
+	for _, c := range collectors {
+		err := reg.Register(c)
+		var already prometheus.AlreadyRegisteredError
+		if err != nil && !errors.As(err, &already) {
+			return err
+		}
+	}
+	return nil
+}
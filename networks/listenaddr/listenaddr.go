@@ -0,0 +1,57 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package listenaddr contains helpers to interpret the listen addresses that
+// Caddy passes to the SCION networks.
+package listenaddr
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// JoinHostPort joins host with the port at portOffset within portRange. Caddy
+// calls the listener function of a network once per port of a range, passing
+// the whole range and the offset of the port to listen on.
+func JoinHostPort(host string, portRange string, portOffset uint) (string, error) {
+	start, end, err := parsePortRange(portRange)
+	if err != nil {
+		return "", err
+	}
+	if uint64(portOffset) > end-start {
+		return "", fmt.Errorf("port offset %d out of range %s", portOffset, portRange)
+	}
+	return net.JoinHostPort(host, strconv.FormatUint(start+uint64(portOffset), 10)), nil
+}
+
+func parsePortRange(portRange string) (uint64, uint64, error) {
+	startStr, endStr, isRange := strings.Cut(portRange, "-")
+	start, err := strconv.ParseUint(startStr, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid start port %q: %w", startStr, err)
+	}
+	if !isRange {
+		return start, start, nil
+	}
+	end, err := strconv.ParseUint(endStr, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid end port %q: %w", endStr, err)
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("end port must not be less than start port: %s", portRange)
+	}
+	return start, end, nil
+}
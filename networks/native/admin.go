@@ -0,0 +1,128 @@
+// Copyright 2024 Anapaya Systems, ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+var (
+	// Interface guards
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// ListenerInfo describes a SCION listener of the network.
+type ListenerInfo struct {
+	// Network of the listener, e.g., scion+udp.
+	Network string `json:"network"`
+	// Address the listener was requested on.
+	Address string `json:"address"`
+	// Address the listener is bound to. It only differs from Address once
+	// the actual host or port have been selected.
+	LocalAddress string `json:"local_address"`
+	// Whether the socket of the listener is bound.
+	Ready bool `json:"ready"`
+}
+
+// Listeners returns the listeners currently held by the network.
+func (n *Network) Listeners() []ListenerInfo {
+	var infos []ListenerInfo
+	n.Pool.Range(func(_ string, c *conn) bool {
+		infos = append(infos, ListenerInfo{
+			Network:      SCIONUDP,
+			Address:      c.addr,
+			LocalAddress: c.LocalAddr().String(),
+			Ready:        c.Ready(),
+		})
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Address < infos[j].Address
+	})
+	return infos
+}
+
+// adminAPI is a module that serves endpoints to inspect the SCION listeners.
+//
+//   - GET /scion/listeners returns the list of listeners.
+//   - GET /scion/ready returns 200 if all the listeners are bound, 503
+//     otherwise. It can be polled by health checks or systemd units that need
+//     to wait for deferred listeners.
+type adminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.scion",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Routes returns the admin routes for the SCION listeners.
+func (a *adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/scion/listeners",
+			Handler: caddy.AdminHandlerFunc(a.handleListeners),
+		},
+		{
+			Pattern: "/scion/ready",
+			Handler: caddy.AdminHandlerFunc(a.handleReady),
+		},
+	}
+}
+
+func (a *adminAPI) handleListeners(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	infos := nativeNetwork.Listeners()
+	if infos == nil {
+		infos = []ListenerInfo{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(infos)
+}
+
+func (a *adminAPI) handleReady(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	for _, info := range nativeNetwork.Listeners() {
+		if !info.Ready {
+			return caddy.APIError{
+				HTTPStatus: http.StatusServiceUnavailable,
+				Err:        fmt.Errorf("listener %s not ready", info.Address),
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,358 @@
+// Copyright 2024 Anapaya Systems, ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/drkey"
+	"github.com/scionproto/scion/pkg/private/ctrl/path_mgmt"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/scionproto/scion/private/app/env"
+	"go.uber.org/zap"
+)
+
+var (
+	_ daemon.Connector = (*daemonConn)(nil)
+)
+
+const (
+	healthCheckInterval = 10 * time.Second
+	minReconnectBackoff = 1 * time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// daemonKey identifies a pooled daemon connection.
+type daemonKey struct {
+	ia      addr.IA
+	address string
+}
+
+// daemonConn is a daemon.Connector shared by all the listeners of an ISD-AS.
+// It periodically checks that the daemon is still reachable, and transparently
+// replaces the underlying connection if it is not, e.g., because the daemon
+// was restarted.
+type daemonConn struct {
+	key     daemonKey
+	network *Network
+
+	mu   sync.RWMutex
+	conn daemon.Connector
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// sciondConn returns the pooled connection to the SCION daemon of the
+// specified ISD-AS, creating it if needed. The daemon address is taken from
+// the configuration, falling back to the SCION environment file. The returned
+// connection must be released with Close.
+func (n *Network) sciondConn(ia addr.IA) (*daemonConn, error) {
+	address, err := daemonAddress(n.Config(), ia)
+	if err != nil {
+		return nil, err
+	}
+	key := daemonKey{ia: ia, address: address}
+	d, loaded, err := n.daemons.LoadOrNew(key, func() (caddy.Destructor, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+		defer cancel()
+		conn, err := findSciond(ctx, address, ia)
+		if err != nil {
+			return nil, err
+		}
+		return newDaemonConn(n, key, conn), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	n.Logger().Debug("using SCION daemon connection",
+		zap.Stringer("ia", ia), zap.String("addr", address), zap.Bool("reuse", loaded))
+	return d, nil
+}
+
+func newDaemonConn(network *Network, key daemonKey, conn daemon.Connector) *daemonConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &daemonConn{
+		key:     key,
+		network: network,
+		conn:    conn,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go d.run(ctx)
+	return d
+}
+
+// run checks the health of the daemon connection until ctx is canceled.
+func (d *daemonConn) run(ctx context.Context) {
+	defer close(d.done)
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, initTimeout)
+		_, err := d.current().LocalIA(checkCtx)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		d.network.Logger().Warn("SCION daemon unreachable, reconnecting",
+			zap.Stringer("ia", d.key.ia), zap.String("addr", d.key.address), zap.Error(err))
+		d.reconnect(ctx)
+	}
+}
+
+// reconnect replaces the underlying connection, retrying with exponential
+// backoff until it succeeds or ctx is canceled.
+func (d *daemonConn) reconnect(ctx context.Context) {
+	backoff := minReconnectBackoff
+	for {
+		connectCtx, cancel := context.WithTimeout(ctx, initTimeout)
+		conn, err := findSciond(connectCtx, d.key.address, d.key.ia)
+		cancel()
+		if err == nil {
+			d.mu.Lock()
+			old := d.conn
+			d.conn = conn
+			d.mu.Unlock()
+			old.Close()
+			d.network.Logger().Info("reconnected to SCION daemon",
+				zap.Stringer("ia", d.key.ia), zap.String("addr", d.key.address))
+			return
+		}
+		d.network.Logger().Debug("failed to reconnect to SCION daemon",
+			zap.Stringer("ia", d.key.ia), zap.String("addr", d.key.address),
+			zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxReconnectBackoff)
+	}
+}
+
+func (d *daemonConn) current() daemon.Connector {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.conn
+}
+
+func (d *daemonConn) LocalIA(ctx context.Context) (addr.IA, error) {
+	return d.current().LocalIA(ctx)
+}
+
+func (d *daemonConn) PortRange(ctx context.Context) (uint16, uint16, error) {
+	return d.current().PortRange(ctx)
+}
+
+func (d *daemonConn) Interfaces(ctx context.Context) (map[uint16]netip.AddrPort, error) {
+	return d.current().Interfaces(ctx)
+}
+
+func (d *daemonConn) Paths(
+	ctx context.Context,
+	dst, src addr.IA,
+	f daemon.PathReqFlags,
+) ([]snet.Path, error) {
+	return d.current().Paths(ctx, dst, src, f)
+}
+
+func (d *daemonConn) ASInfo(ctx context.Context, ia addr.IA) (daemon.ASInfo, error) {
+	return d.current().ASInfo(ctx, ia)
+}
+
+func (d *daemonConn) SVCInfo(
+	ctx context.Context,
+	svcTypes []addr.SVC,
+) (map[addr.SVC][]string, error) {
+	return d.current().SVCInfo(ctx, svcTypes)
+}
+
+func (d *daemonConn) RevNotification(ctx context.Context, revInfo *path_mgmt.RevInfo) error {
+	return d.current().RevNotification(ctx, revInfo)
+}
+
+func (d *daemonConn) DRKeyGetASHostKey(
+	ctx context.Context,
+	meta drkey.ASHostMeta,
+) (drkey.ASHostKey, error) {
+	return d.current().DRKeyGetASHostKey(ctx, meta)
+}
+
+func (d *daemonConn) DRKeyGetHostASKey(
+	ctx context.Context,
+	meta drkey.HostASMeta,
+) (drkey.HostASKey, error) {
+	return d.current().DRKeyGetHostASKey(ctx, meta)
+}
+
+func (d *daemonConn) DRKeyGetHostHostKey(
+	ctx context.Context,
+	meta drkey.HostHostMeta,
+) (drkey.HostHostKey, error) {
+	return d.current().DRKeyGetHostHostKey(ctx, meta)
+}
+
+// Close removes the reference in the usage pool. If the references go to zero,
+// the connection is destroyed.
+func (d *daemonConn) Close() error {
+	_, err := d.network.daemons.Delete(d.key)
+	return err
+}
+
+// Destruct stops the health checks and closes the connection. It is called by
+// the usage pool when the reference count goes to zero.
+func (d *daemonConn) Destruct() error {
+	d.network.Logger().Debug("closing SCION daemon connection",
+		zap.Stringer("ia", d.key.ia), zap.String("addr", d.key.address))
+
+	d.cancel()
+	<-d.done
+	return d.current().Close()
+}
+
+// daemonAddress returns the address of the SCION daemon of the specified
+// ISD-AS. The configured daemons take precedence over the environment file.
+func daemonAddress(cfg Config, ia addr.IA) (string, error) {
+	if address, ok := cfg.Daemons[ia]; ok {
+		return address, nil
+	}
+	env, err := loadEnv(cfg.EnvironmentFile)
+	if err != nil {
+		return "", err
+	}
+	as, ok := env.ASes[ia]
+	if !ok {
+		return "", &UnknownIAError{IA: ia}
+	}
+	return as.DaemonAddress, nil
+}
+
+// localIAs returns the ISD-ASes that have a SCION daemon configured, either in
+// the configuration or in the environment file.
+func localIAs(cfg Config) ([]addr.IA, error) {
+	seen := make(map[addr.IA]struct{})
+	for ia := range cfg.Daemons {
+		seen[ia] = struct{}{}
+	}
+	env, err := loadEnv(cfg.EnvironmentFile)
+	// The default environment file is optional if daemons are configured.
+	if err != nil && (len(seen) == 0 || cfg.EnvironmentFile != "") {
+		return nil, err
+	}
+	for ia := range env.ASes {
+		seen[ia] = struct{}{}
+	}
+	ias := make([]addr.IA, 0, len(seen))
+	for ia := range seen {
+		ias = append(ias, ia)
+	}
+	sort.Slice(ias, func(i, j int) bool { return ias[i] < ias[j] })
+	return ias, nil
+}
+
+// localIP returns the IP of this host in the AS of the daemon, i.e., the
+// source IP of the route towards the border routers of the AS. IPs of the same
+// family as want are preferred.
+func localIP(ctx context.Context, sd daemon.Connector, want net.IP) (net.IP, error) {
+	ifs, err := sd.Interfaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint16, 0, len(ifs))
+	for id := range ifs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	wantV4 := want == nil || want.To4() != nil
+	var fallback net.IP
+	for _, id := range ids {
+		ip, err := routeSourceIP(ifs[id])
+		if err != nil {
+			continue
+		}
+		if (ip.To4() != nil) == wantV4 {
+			return ip, nil
+		}
+		if fallback == nil {
+			fallback = ip
+		}
+	}
+	if fallback == nil {
+		return nil, fmt.Errorf("no route to the border routers of the AS")
+	}
+	return fallback, nil
+}
+
+// routeSourceIP returns the source IP the host uses to reach dst. No packet is
+// sent.
+func routeSourceIP(dst netip.AddrPort) (net.IP, error) {
+	c, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(dst))
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func findSciond(ctx context.Context, daemonAddr string, ia addr.IA) (daemon.Connector, error) {
+	sciondConn, err := daemon.NewService(daemonAddr).Connect(ctx)
+	if err != nil {
+		return nil, &DaemonUnreachableError{IA: ia, Address: daemonAddr, Err: err}
+	}
+	// Connecting does not necessarily talk to the daemon, so we issue a
+	// request to make sure it is up.
+	if _, err := sciondConn.LocalIA(ctx); err != nil {
+		sciondConn.Close()
+		return nil, &DaemonUnreachableError{IA: ia, Address: daemonAddr, Err: err}
+	}
+	return sciondConn, nil
+}
+
+func loadEnv(envFile string) (env.SCION, error) {
+	if envFile == "" {
+		envFile = os.Getenv("SCION_ENV_FILE")
+	}
+	if envFile == "" {
+		envFile = "/etc/scion/environment.json"
+	}
+	raw, err := os.ReadFile(envFile)
+	if err != nil {
+		return env.SCION{}, &EnvironmentError{Path: envFile, Err: err}
+	}
+	var e env.SCION
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return env.SCION{}, &EnvironmentError{Path: envFile, Err: err}
+	}
+	return e, nil
+}
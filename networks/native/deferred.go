@@ -0,0 +1,230 @@
+// Copyright 2024 Anapaya Systems, ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+	"go.uber.org/zap"
+)
+
+var (
+	_ net.PacketConn = (*deferredConn)(nil)
+
+	// ErrNotReady is returned when writing to a deferred listener that is
+	// not bound yet.
+	ErrNotReady = errors.New("SCION listener not ready")
+)
+
+// deferredConn is a net.PacketConn whose socket is only bound once the SCION
+// daemon becomes reachable. Until then, reads block and writes fail with
+// ErrNotReady. Binding is retried in the background with exponential backoff.
+type deferredConn struct {
+	laddr   *snet.UDPAddr
+	network *Network
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu            sync.Mutex
+	conn          net.PacketConn
+	daemon        *daemonConn
+	readDeadline  time.Time
+	writeDeadline time.Time
+	// wake is closed and replaced whenever the state changes, to wake up
+	// blocked readers.
+	wake chan struct{}
+}
+
+func newDeferredConn(network *Network, laddr *snet.UDPAddr) *deferredConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &deferredConn{
+		laddr:   laddr,
+		network: network,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		wake:    make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// run tries to bind the socket until it succeeds or the connection is closed.
+func (d *deferredConn) run() {
+	defer close(d.done)
+
+	backoff := minReconnectBackoff
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		c, sd, err := bind(d.ctx, d.network, d.laddr)
+		if err != nil {
+			d.network.Logger().Debug("failed to bind deferred listener",
+				zap.String("addr", d.laddr.String()), zap.Duration("backoff", backoff), zap.Error(err))
+			backoff = min(2*backoff, maxReconnectBackoff)
+			continue
+		}
+
+		d.mu.Lock()
+		if d.ctx.Err() != nil {
+			d.mu.Unlock()
+			c.Close()
+			sd.Close()
+			return
+		}
+		if !d.readDeadline.IsZero() {
+			c.SetReadDeadline(d.readDeadline)
+		}
+		if !d.writeDeadline.IsZero() {
+			c.SetWriteDeadline(d.writeDeadline)
+		}
+		d.conn = c
+		d.daemon = sd
+		d.notifyLocked()
+		d.mu.Unlock()
+
+		d.network.Logger().Info("bound deferred listener",
+			zap.String("addr", d.laddr.String()), zap.Stringer("local_addr", c.LocalAddr()))
+		return
+	}
+}
+
+// Ready reports whether the socket has been bound.
+func (d *deferredConn) Ready() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conn != nil
+}
+
+func (d *deferredConn) bound() net.PacketConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conn
+}
+
+func (d *deferredConn) notifyLocked() {
+	close(d.wake)
+	d.wake = make(chan struct{})
+}
+
+func (d *deferredConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		d.mu.Lock()
+		if c := d.conn; c != nil {
+			d.mu.Unlock()
+			return c.ReadFrom(b)
+		}
+		deadline, wake := d.readDeadline, d.wake
+		d.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		var err error
+		select {
+		case <-d.ctx.Done():
+			err = net.ErrClosed
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		case <-wake:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+func (d *deferredConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c := d.bound(); c != nil {
+		return c.WriteTo(b, addr)
+	}
+	if d.ctx.Err() != nil {
+		return 0, net.ErrClosed
+	}
+	return 0, ErrNotReady
+}
+
+func (d *deferredConn) LocalAddr() net.Addr {
+	if c := d.bound(); c != nil {
+		return c.LocalAddr()
+	}
+	return d.laddr
+}
+
+func (d *deferredConn) SetDeadline(t time.Time) error {
+	if err := d.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return d.SetWriteDeadline(t)
+}
+
+func (d *deferredConn) SetReadDeadline(t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		return d.conn.SetReadDeadline(t)
+	}
+	d.readDeadline = t
+	d.notifyLocked()
+	return nil
+}
+
+func (d *deferredConn) SetWriteDeadline(t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		return d.conn.SetWriteDeadline(t)
+	}
+	d.writeDeadline = t
+	return nil
+}
+
+// Close stops the background binding and closes the socket if it was bound.
+func (d *deferredConn) Close() error {
+	d.cancel()
+	<-d.done
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.Close()
+	if derr := d.daemon.Close(); err == nil {
+		err = derr
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Anapaya Systems, ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"fmt"
+
+	"github.com/scionproto/scion/pkg/addr"
+)
+
+// EnvironmentError is returned by Network.Listen if the SCION environment
+// file cannot be read or parsed.
+type EnvironmentError struct {
+	Path string
+	Err  error
+}
+
+func (e *EnvironmentError) Error() string {
+	return fmt.Sprintf("loading SCION environment %s: %v", e.Path, e.Err)
+}
+
+func (e *EnvironmentError) Unwrap() error {
+	return e.Err
+}
+
+// UnknownIAError is returned by Network.Listen if no daemon is configured for
+// the ISD-AS of the listening address.
+type UnknownIAError struct {
+	IA addr.IA
+}
+
+func (e *UnknownIAError) Error() string {
+	return fmt.Sprintf("AS %s not found in environment", e.IA)
+}
+
+// DaemonUnreachableError is returned by Network.Listen if the SCION daemon of
+// the ISD-AS of the listening address cannot be reached.
+type DaemonUnreachableError struct {
+	IA      addr.IA
+	Address string
+	Err     error
+}
+
+func (e *DaemonUnreachableError) Error() string {
+	return fmt.Sprintf("unable to connect to AS %s SCIOND at %s: %v", e.IA, e.Address, e.Err)
+}
+
+func (e *DaemonUnreachableError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,284 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+	"golang.org/x/time/rate"
+)
+
+var (
+	_ net.PacketConn = (*guardConn)(nil)
+)
+
+const (
+	// connIdleTimeout is the time after which a remote that sent no packets
+	// no longer counts as a connection.
+	connIdleTimeout = 30 * time.Second
+	// guardSweepInterval is the interval at which idle connections and
+	// sources are forgotten.
+	guardSweepInterval = 10 * time.Second
+
+	quicVersion1 = 0x00000001
+	quicVersion2 = 0x6b3343cf
+)
+
+// Reasons for dropping packets, used as metric labels.
+const (
+	dropPacketRate    = "packet_rate"
+	dropHandshakeRate = "handshake_rate"
+	dropConnections   = "connections"
+)
+
+// Limits protect the listeners from sources that send too much traffic. Zero
+// values disable the respective limit. Dropped packets are counted by the
+// caddy_scion_guard_drops_total metric.
+type Limits struct {
+	// PacketRate is the number of packets per second accepted from a source,
+	// with bursts of up to PacketBurst packets.
+	PacketRate  float64 `json:"packet_rate,omitempty"`
+	PacketBurst int     `json:"packet_burst,omitempty"`
+	// HandshakeRate is the number of new QUIC connections per second
+	// accepted from a source, with bursts of up to HandshakeBurst
+	// connections.
+	HandshakeRate  float64 `json:"handshake_rate,omitempty"`
+	HandshakeBurst int     `json:"handshake_burst,omitempty"`
+	// PerHost applies the rate limits per source host instead of per source
+	// ISD-AS.
+	PerHost bool `json:"per_host,omitempty"`
+	// MaxConnections is the maximum number of concurrent connections per
+	// source ISD-AS. A remote counts as a connection from its first QUIC
+	// Initial packet until it has been idle for 30 seconds.
+	MaxConnections int `json:"max_connections,omitempty"`
+}
+
+func (l Limits) enabled() bool {
+	return l.PacketRate > 0 || l.HandshakeRate > 0 || l.MaxConnections > 0
+}
+
+// GuardMetrics are the metrics recorded for the packets dropped because of
+// the limits.
+type GuardMetrics struct {
+	// Drops counts the dropped packets by source ISD-AS and reason.
+	Drops *prometheus.CounterVec
+}
+
+// NewGuardMetrics creates the guard metrics. They are not registered.
+func NewGuardMetrics() GuardMetrics {
+	return GuardMetrics{
+		Drops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "caddy",
+			Subsystem: "scion",
+			Name:      "guard_drops_total",
+			Help:      "Total number of packets dropped because of the listener limits.",
+		}, []string{"isd_as", "reason"}),
+	}
+}
+
+// Collectors returns the collectors to be registered.
+func (m GuardMetrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.Drops}
+}
+
+// SetGuardMetrics sets the metrics of the listener limits. It is safe to
+// access concurrently.
+func (n *Network) SetGuardMetrics(metrics GuardMetrics) {
+	n.guardMetrics.Store(&metrics)
+}
+
+// GuardMetrics gets the metrics of the listener limits. The collectors are nil
+// if none are set.
+func (n *Network) GuardMetrics() GuardMetrics {
+	if m := n.guardMetrics.Load(); m != nil {
+		return *m
+	}
+	return GuardMetrics{}
+}
+
+// guardConn drops the packets of the sources exceeding the limits before
+// they reach QUIC. The limits are looked up in the network config for every
+// packet, so that a config reload applies to the listeners kept across it.
+type guardConn struct {
+	net.PacketConn
+	network *Network
+
+	mu sync.Mutex
+	// limits are the limits the rate limiters of the sources were created
+	// with.
+	limits    Limits
+	sources   map[string]*source
+	conns     map[string]remoteConn
+	perIA     map[addr.IA]int
+	lastSweep time.Time
+}
+
+type source struct {
+	packets    *rate.Limiter
+	handshakes *rate.Limiter
+	lastSeen   time.Time
+}
+
+type remoteConn struct {
+	ia       addr.IA
+	lastSeen time.Time
+}
+
+func newGuardConn(c net.PacketConn, network *Network) *guardConn {
+	return &guardConn{
+		PacketConn: c,
+		network:    network,
+		sources:    make(map[string]*source),
+		conns:      make(map[string]remoteConn),
+		perIA:      make(map[addr.IA]int),
+		lastSweep:  time.Now(),
+	}
+}
+
+func (g *guardConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, a, err := g.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, a, err
+		}
+		src, ok := a.(*snet.UDPAddr)
+		if !ok {
+			return n, a, nil
+		}
+		var limits Limits
+		if cfg := g.network.config.Load(); cfg != nil {
+			limits = cfg.Limits
+		}
+		if !limits.enabled() {
+			return n, a, nil
+		}
+		reason := g.admit(limits, src, isQUICInitial(b[:n]))
+		if reason == "" {
+			return n, a, nil
+		}
+		if m := g.network.GuardMetrics().Drops; m != nil {
+			m.WithLabelValues(src.IA.String(), reason).Inc()
+		}
+	}
+}
+
+// admit returns the reason for dropping the packet from src, or the empty
+// string if it is accepted.
+func (g *guardConn) admit(limits Limits, src *snet.UDPAddr, initial bool) string {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if limits != g.limits {
+		// The rate limiters are recreated with the new limits.
+		g.limits = limits
+		clear(g.sources)
+	}
+	g.sweep(now)
+
+	s := g.source(src, now)
+	if s.packets != nil && !s.packets.AllowN(now, 1) {
+		return dropPacketRate
+	}
+
+	remote := src.IA.String() + "," + src.Host.String()
+	if c, ok := g.conns[remote]; ok {
+		c.lastSeen = now
+		g.conns[remote] = c
+		return ""
+	}
+	if !initial {
+		return ""
+	}
+	if s.handshakes != nil && !s.handshakes.AllowN(now, 1) {
+		return dropHandshakeRate
+	}
+	if g.limits.MaxConnections > 0 && g.perIA[src.IA] >= g.limits.MaxConnections {
+		return dropConnections
+	}
+	g.conns[remote] = remoteConn{ia: src.IA, lastSeen: now}
+	g.perIA[src.IA]++
+	return ""
+}
+
+func (g *guardConn) source(src *snet.UDPAddr, now time.Time) *source {
+	key := src.IA.String()
+	if g.limits.PerHost {
+		key += "," + src.Host.IP.String()
+	}
+	s, ok := g.sources[key]
+	if !ok {
+		s = &source{}
+		if g.limits.PacketRate > 0 {
+			s.packets = rate.NewLimiter(rate.Limit(g.limits.PacketRate), max(g.limits.PacketBurst, 1))
+		}
+		if g.limits.HandshakeRate > 0 {
+			s.handshakes = rate.NewLimiter(rate.Limit(g.limits.HandshakeRate), max(g.limits.HandshakeBurst, 1))
+		}
+		g.sources[key] = s
+	}
+	s.lastSeen = now
+	return s
+}
+
+// sweep forgets idle connections, and sources whose buckets are full again.
+func (g *guardConn) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < guardSweepInterval {
+		return
+	}
+	g.lastSweep = now
+	for k, c := range g.conns {
+		if now.Sub(c.lastSeen) > connIdleTimeout {
+			delete(g.conns, k)
+			if g.perIA[c.ia]--; g.perIA[c.ia] <= 0 {
+				delete(g.perIA, c.ia)
+			}
+		}
+	}
+	for k, s := range g.sources {
+		if now.Sub(s.lastSeen) > connIdleTimeout {
+			delete(g.sources, k)
+		}
+	}
+}
+
+// Ready reports whether the underlying socket is bound.
+func (g *guardConn) Ready() bool {
+	if r, ok := g.PacketConn.(interface{ Ready() bool }); ok {
+		return r.Ready()
+	}
+	return true
+}
+
+// isQUICInitial reports whether b is a QUIC Initial packet, i.e., the first
+// packet of a new connection.
+func isQUICInitial(b []byte) bool {
+	if len(b) < 5 || b[0]&0x80 == 0 {
+		return false
+	}
+	packetType := (b[0] & 0x30) >> 4
+	switch binary.BigEndian.Uint32(b[1:5]) {
+	case quicVersion1:
+		return packetType == 0
+	case quicVersion2:
+		return packetType == 1
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"io"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/scionproto/scion/pkg/snet"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/pool"
+)
+
+var (
+	// quicInitial is the start of a QUIC version 1 Initial packet.
+	quicInitial = []byte{0xc0, 0x00, 0x00, 0x00, 0x01}
+	// quicShort is the start of a QUIC short header packet.
+	quicShort = []byte{0x40, 0x01, 0x02, 0x03, 0x04}
+)
+
+type queuedPacket struct {
+	payload []byte
+	src     net.Addr
+}
+
+// queueConn reads the queued packets, and returns io.EOF once the queue is
+// empty.
+type queueConn struct {
+	net.PacketConn
+	queue []queuedPacket
+}
+
+func (c *queueConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if len(c.queue) == 0 {
+		return 0, nil, io.EOF
+	}
+	p := c.queue[0]
+	c.queue = c.queue[1:]
+	return copy(b, p.payload), p.src, nil
+}
+
+func TestGuardConnFollowsConfig(t *testing.T) {
+	network := NewNetwork(pool.NewUsagePool[string, *conn]())
+	qc := &queueConn{}
+	g := newGuardConn(qc, network)
+
+	src := func(s string) *snet.UDPAddr {
+		a, err := snet.ParseUDPAddr(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	var (
+		a111 = src("1-ff00:0:111,[10.0.0.1]:1")
+		b111 = src("1-ff00:0:111,[10.0.0.2]:1")
+		c112 = src("1-ff00:0:112,[10.0.0.1]:1")
+		udp  = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}
+	)
+	// deliver feeds the packets to the guard and returns the sources of the
+	// packets it passed on.
+	deliver := func(pkts ...queuedPacket) []string {
+		t.Helper()
+		qc.queue = pkts
+		var got []string
+		b := make([]byte, 1500)
+		for {
+			_, a, err := g.ReadFrom(b)
+			if err == io.EOF {
+				return got
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, a.String())
+		}
+	}
+	check := func(name string, got []string, want ...net.Addr) {
+		t.Helper()
+		var w []string
+		for _, a := range want {
+			w = append(w, a.String())
+		}
+		if !slices.Equal(got, w) {
+			t.Errorf("%s: got %v, want %v", name, got, w)
+		}
+	}
+
+	// The packet rate is limited per ISD-AS.
+	network.SetConfig(Config{Limits: Limits{PacketRate: 0.001, PacketBurst: 1}})
+	check("packet rate",
+		deliver(queuedPacket{quicShort, a111}, queuedPacket{quicShort, a111}, queuedPacket{quicShort, b111},
+			queuedPacket{quicShort, c112}, queuedPacket{quicShort, udp}),
+		a111, c112, udp)
+
+	// Reloaded limits replace the rate limiters.
+	network.SetConfig(Config{Limits: Limits{PacketRate: 0.001, PacketBurst: 1, PerHost: true}})
+	check("packet rate per host",
+		deliver(queuedPacket{quicShort, a111}, queuedPacket{quicShort, a111}, queuedPacket{quicShort, b111},
+			queuedPacket{quicShort, c112}),
+		a111, b111, c112)
+
+	// Only Initial packets open connections.
+	network.SetConfig(Config{Limits: Limits{MaxConnections: 1}})
+	check("connections",
+		deliver(queuedPacket{quicInitial, a111}, queuedPacket{quicInitial, b111}, queuedPacket{quicShort, b111},
+			queuedPacket{quicInitial, c112}, queuedPacket{quicShort, a111}),
+		a111, b111, c112, a111)
+
+	// The connections are kept across reloads.
+	network.SetConfig(Config{Limits: Limits{MaxConnections: 2}})
+	check("connections after reload",
+		deliver(queuedPacket{quicInitial, b111}, queuedPacket{quicInitial, src("1-ff00:0:111,[10.0.0.3]:1")}),
+		b111)
+
+	// Without limits, everything is passed on.
+	network.SetConfig(Config{})
+	check("no limits",
+		deliver(queuedPacket{quicInitial, a111}, queuedPacket{quicInitial, b111}, queuedPacket{quicShort, c112}),
+		a111, b111, c112)
+}
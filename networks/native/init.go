@@ -20,6 +20,7 @@ import (
 	"github.com/scionproto/scion/pkg/snet"
 	"go.uber.org/zap"
 
+	"github.com/scionproto-contrib/caddy-scion/networks/connmetrics"
 	"github.com/scionproto-contrib/caddy-scion/networks/pool"
 )
 
@@ -37,6 +38,30 @@ func SetLogger(logger *zap.Logger) {
 	nativeNetwork.SetLogger(logger)
 }
 
-func SetPacketConnMetrics(metrics snet.SCIONPacketConnMetrics) {
+func SetConfig(cfg Config) (restore func()) {
+	return nativeNetwork.SetConfig(cfg)
+}
+
+func SetPacketConnMetrics(metrics *connmetrics.PacketConnMetrics) {
 	nativeNetwork.SetPacketConnMetrics(metrics)
 }
+
+func SetSCMPMetrics(metrics SCMPMetrics) {
+	nativeNetwork.SetSCMPMetrics(metrics)
+}
+
+func SetGuardMetrics(metrics GuardMetrics) {
+	nativeNetwork.SetGuardMetrics(metrics)
+}
+
+func SetSCMPHandler(h snet.SCMPHandler) {
+	nativeNetwork.SetSCMPHandler(h)
+}
+
+func SubscribeRevocations(f func(RevocationEvent)) func() {
+	return nativeNetwork.SubscribeRevocations(f)
+}
+
+func NewPathResolver() *PathResolver {
+	return nativeNetwork.NewPathResolver()
+}
@@ -0,0 +1,312 @@
+// Copyright 2024 Anapaya Systems, ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/private/common"
+	"github.com/scionproto/scion/pkg/snet"
+	"go.uber.org/zap"
+)
+
+var (
+	_ net.PacketConn = (*multiConn)(nil)
+)
+
+const (
+	// routeExpiry is the time after which the socket a remote was last seen
+	// on is forgotten.
+	routeExpiry = 10 * time.Minute
+)
+
+var packetBufs = sync.Pool{
+	New: func() any {
+		b := make([]byte, common.SupportedMTU)
+		return &b
+	},
+}
+
+// listenAllASes opens one socket per local ISD-AS for the wildcard address
+// laddr, e.g., [0-0,10.0.0.1]:443, and multiplexes them into a single
+// net.PacketConn.
+func listenAllASes(
+	ctx context.Context,
+	network *Network,
+	laddr *snet.UDPAddr,
+) (net.PacketConn, error) {
+	if laddr.Host.Port == 0 {
+		return nil, fmt.Errorf("wildcard port not supported with wildcard ISD-AS: %s", laddr)
+	}
+	ias, err := localIAs(network.Config())
+	if err != nil {
+		return nil, err
+	}
+	if len(ias) == 0 {
+		return nil, fmt.Errorf("no local ISD-AS to listen on: %s", laddr)
+	}
+	m := &multiConn{
+		laddr:   laddr,
+		network: network,
+		packets: make(chan packet),
+		closed:  make(chan struct{}),
+		wake:    make(chan struct{}),
+		routes:  make(map[string]route),
+	}
+	for _, ia := range ias {
+		member := *laddr
+		member.IA = ia
+		c, sd, err := open(ctx, network, &member)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("listening on %s: %w", &member, err)
+		}
+		m.members = append(m.members, multiMember{conn: c, daemon: sd})
+	}
+	for i := range m.members {
+		m.readers.Add(1)
+		go m.read(i)
+	}
+	network.Logger().Debug("created new scion+udp listener on all local ASes",
+		zap.String("addr", laddr.String()), zap.Stringers("ias", ias))
+	return m, nil
+}
+
+type multiMember struct {
+	conn   net.PacketConn
+	daemon *daemonConn
+}
+
+type packet struct {
+	buf    *[]byte
+	n      int
+	addr   net.Addr
+	member int
+}
+
+type route struct {
+	member   int
+	lastSeen time.Time
+}
+
+// multiConn is a net.PacketConn reading from the sockets of several local
+// ISD-ASes. Writes are sent through the socket the remote was last seen on.
+type multiConn struct {
+	laddr   *snet.UDPAddr
+	network *Network
+	members []multiMember
+	readers sync.WaitGroup
+
+	packets   chan packet
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	// wake is closed and replaced whenever the read deadline changes, to wake
+	// up blocked readers.
+	wake      chan struct{}
+	routes    map[string]route
+	lastSweep time.Time
+}
+
+// read forwards the packets of a member socket until the connection or the
+// socket is closed. Other errors, e.g., caused by a malformed packet, only
+// concern the packet at hand, so they are logged and reading goes on.
+func (m *multiConn) read(member int) {
+	defer m.readers.Done()
+	var backoff time.Duration
+	for {
+		buf := packetBufs.Get().(*[]byte)
+		n, addr, err := m.members[member].conn.ReadFrom(*buf)
+		if err != nil {
+			packetBufs.Put(buf)
+			if errors.Is(err, net.ErrClosed) {
+				select {
+				case <-m.closed:
+				default:
+					m.network.Logger().Error("socket of scion+udp listener closed",
+						zap.String("addr", m.laddr.String()),
+						zap.Stringer("local_addr", m.members[member].conn.LocalAddr()))
+				}
+				return
+			}
+			m.network.Logger().Debug("failed to read from scion+udp listener",
+				zap.Stringer("local_addr", m.members[member].conn.LocalAddr()), zap.Error(err))
+			// Back off on consecutive errors, so that a persistently failing
+			// socket does not spin.
+			select {
+			case <-m.closed:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(max(2*backoff, time.Millisecond), time.Second)
+			continue
+		}
+		backoff = 0
+		select {
+		case m.packets <- packet{buf: buf, n: n, addr: addr, member: member}:
+		case <-m.closed:
+			packetBufs.Put(buf)
+			return
+		}
+	}
+}
+
+func (m *multiConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		m.mu.Lock()
+		deadline, wake := m.readDeadline, m.wake
+		m.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		var (
+			p   packet
+			ok  bool
+			err error
+		)
+		select {
+		case p = <-m.packets:
+			ok = true
+		case <-m.closed:
+			err = net.ErrClosed
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		case <-wake:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		n := copy(b, (*p.buf)[:p.n])
+		packetBufs.Put(p.buf)
+		m.learn(p.addr, p.member)
+		return n, p.addr, nil
+	}
+}
+
+// learn records the socket the remote was seen on.
+func (m *multiConn) learn(addr net.Addr, member int) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routes[addr.String()] = route{member: member, lastSeen: now}
+	if now.Sub(m.lastSweep) < routeExpiry {
+		return
+	}
+	for k, r := range m.routes {
+		if now.Sub(r.lastSeen) > routeExpiry {
+			delete(m.routes, k)
+		}
+	}
+	m.lastSweep = now
+}
+
+func (m *multiConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	m.mu.Lock()
+	r, ok := m.routes[addr.String()]
+	m.mu.Unlock()
+	if !ok {
+		// Fall back to the socket of the remote's ISD-AS, which is correct
+		// for AS-local remotes.
+		dst, isSCION := addr.(*snet.UDPAddr)
+		for i, member := range m.members {
+			local, isLocalSCION := member.conn.LocalAddr().(*snet.UDPAddr)
+			if isSCION && isLocalSCION && local.IA == dst.IA {
+				r, ok = route{member: i}, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return 0, fmt.Errorf("no local socket known for remote %s", addr)
+	}
+	return m.members[r.member].conn.WriteTo(b, addr)
+}
+
+func (m *multiConn) LocalAddr() net.Addr {
+	return m.laddr
+}
+
+func (m *multiConn) SetDeadline(t time.Time) error {
+	if err := m.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return m.SetWriteDeadline(t)
+}
+
+func (m *multiConn) SetReadDeadline(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readDeadline = t
+	close(m.wake)
+	m.wake = make(chan struct{})
+	return nil
+}
+
+func (m *multiConn) SetWriteDeadline(t time.Time) error {
+	var errs []error
+	for _, member := range m.members {
+		errs = append(errs, member.conn.SetWriteDeadline(t))
+	}
+	return errors.Join(errs...)
+}
+
+// Ready reports whether the sockets of all the ISD-ASes are bound.
+func (m *multiConn) Ready() bool {
+	for _, member := range m.members {
+		if r, ok := member.conn.(interface{ Ready() bool }); ok && !r.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes the sockets of all the ISD-ASes.
+func (m *multiConn) Close() error {
+	var errs []error
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		for _, member := range m.members {
+			errs = append(errs, member.conn.Close())
+			if member.daemon != nil {
+				errs = append(errs, member.daemon.Close())
+			}
+		}
+		m.readers.Wait()
+	})
+	return errors.Join(errs...)
+}
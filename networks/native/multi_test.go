@@ -0,0 +1,142 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/pool"
+)
+
+// scriptedConn returns the scripted results from ReadFrom, and then blocks
+// until closed.
+type scriptedConn struct {
+	net.PacketConn
+	reads  chan scriptedRead
+	closed chan struct{}
+}
+
+type scriptedRead struct {
+	payload string
+	err     error
+}
+
+func newScriptedConn(reads ...scriptedRead) *scriptedConn {
+	c := &scriptedConn{
+		reads:  make(chan scriptedRead, len(reads)),
+		closed: make(chan struct{}),
+	}
+	for _, r := range reads {
+		c.reads <- r
+	}
+	return c
+}
+
+func (c *scriptedConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case r := <-c.reads:
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		remote, _ := snet.ParseUDPAddr("1-ff00:0:111,[10.0.0.2]:1234")
+		return copy(b, r.payload), remote, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *scriptedConn) LocalAddr() net.Addr {
+	a, _ := snet.ParseUDPAddr("1-ff00:0:110,[10.0.0.1]:443")
+	return a
+}
+
+func (c *scriptedConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestMultiConnSurvivesReadErrors(t *testing.T) {
+	network := NewNetwork(pool.NewUsagePool[string, *conn]())
+	network.SetLogger(zap.NewNop())
+	laddr, _ := snet.ParseUDPAddr("0-0,[10.0.0.1]:443")
+	m := &multiConn{
+		laddr:   laddr,
+		network: network,
+		packets: make(chan packet),
+		closed:  make(chan struct{}),
+		wake:    make(chan struct{}),
+		routes:  make(map[string]route),
+		members: []multiMember{{conn: newScriptedConn(
+			scriptedRead{err: errors.New("unexpected payload")},
+			scriptedRead{err: errors.New("packet is destined to a different host")},
+			scriptedRead{payload: "hello"},
+		)}},
+	}
+	m.readers.Add(1)
+	go m.read(0)
+	defer m.Close()
+
+	if err := m.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 16)
+	n, _, err := m.ReadFrom(b)
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := string(b[:n]); got != "hello" {
+		t.Fatalf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestMultiConnClose(t *testing.T) {
+	network := NewNetwork(pool.NewUsagePool[string, *conn]())
+	network.SetLogger(zap.NewNop())
+	laddr, _ := snet.ParseUDPAddr("0-0,[10.0.0.1]:443")
+	m := &multiConn{
+		laddr:   laddr,
+		network: network,
+		packets: make(chan packet),
+		closed:  make(chan struct{}),
+		wake:    make(chan struct{}),
+		routes:  make(map[string]route),
+		members: []multiMember{{conn: newScriptedConn()}},
+	}
+	m.readers.Add(1)
+	go m.read(0)
+
+	done := make(chan error)
+	go func() {
+		_, _, err := m.ReadFrom(make([]byte, 16))
+		done <- err
+	}()
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("got %v, want %v", err, net.ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadFrom did not return after Close")
+	}
+}
@@ -16,21 +16,20 @@ package native
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
-	"os"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/scionproto/scion/pkg/addr"
-	"github.com/scionproto/scion/pkg/daemon"
 	"github.com/scionproto/scion/pkg/snet"
-	"github.com/scionproto/scion/private/app/env"
 	"go.uber.org/zap"
 
+	"github.com/scionproto-contrib/caddy-scion/networks/connmetrics"
+	"github.com/scionproto-contrib/caddy-scion/networks/listenaddr"
 	"github.com/scionproto-contrib/caddy-scion/networks/pool"
 )
 
@@ -53,22 +52,59 @@ const (
 type listener interface {
 	listen(ctx context.Context,
 		network *Network,
+		key string,
 		laddr *snet.UDPAddr,
 		cfg net.ListenConfig) (caddy.Destructor, error)
 }
 
+// Config holds the settings used to reach the SCION daemons.
+type Config struct {
+	// EnvironmentFile is the path to the SCION environment file. If empty,
+	// SCION_ENV_FILE is consulted, falling back to /etc/scion/environment.json.
+	EnvironmentFile string
+	// Daemons maps ISD-ASes to daemon addresses. It takes precedence over the
+	// environment file.
+	Daemons map[addr.IA]string
+	// Lazy defers binding listeners whose SCION daemon is not reachable yet,
+	// instead of failing. The socket is bound in the background as soon as
+	// the daemon answers. It only applies when a listener is created: the
+	// listeners kept across a config reload are not affected, e.g., a
+	// deferred listener keeps binding in the background even if Lazy is
+	// turned off. Changing it requires a restart to take effect on those.
+	Lazy bool
+	// ReplyPath selects the path replies are sent on. If nil, replies are
+	// sent on the reversed incoming path. It is looked up on every write, so
+	// it also applies to the listeners kept across config reloads.
+	ReplyPath ReplyPathSelector
+	// Limits are enforced per listener on the packets received from remote
+	// sources. They are looked up for every packet, so they also apply to the
+	// listeners kept across config reloads.
+	Limits Limits
+}
+
 // Network is a custom network that allows to listen on SCION addresses.
 type Network struct {
-	Pool              *pool.UsagePool[string, *conn]
-	PacketConnMetrics snet.SCIONPacketConnMetrics
-
-	logger   atomic.Pointer[zap.Logger]
-	listener listener
+	Pool *pool.UsagePool[string, *conn]
+
+	logger      atomic.Pointer[zap.Logger]
+	config      atomic.Pointer[Config]
+	daemons     *pool.UsagePool[daemonKey, *daemonConn]
+	listener    listener
+	wildcards   atomic.Uint64
+	scmpHandler atomic.Pointer[snet.SCMPHandler]
+	revocations revocationSubscribers
+
+	// The metrics are set on every config load, while the listeners kept
+	// across loads record them.
+	packetConnMetrics atomic.Pointer[connmetrics.PacketConnMetrics]
+	scmpMetrics       atomic.Pointer[SCMPMetrics]
+	guardMetrics      atomic.Pointer[GuardMetrics]
 }
 
-func NewNetwork(pool *pool.UsagePool[string, *conn]) *Network {
+func NewNetwork(p *pool.UsagePool[string, *conn]) *Network {
 	return &Network{
-		Pool:     pool,
+		Pool:     p,
+		daemons:  pool.NewUsagePool[daemonKey, *daemonConn](),
 		listener: &listenerSCIONUDP{},
 	}
 }
@@ -78,8 +114,37 @@ func (n *Network) SetLogger(logger *zap.Logger) {
 	n.logger.Store(logger)
 }
 
-func (n *Network) SetPacketConnMetrics(metrics snet.SCIONPacketConnMetrics) {
-	n.PacketConnMetrics = metrics
+// SetConfig sets the configuration used by listeners created afterwards. It
+// returns a function restoring the previous configuration, unless another one
+// has been set in the meantime, so that a config load that fails after setting
+// it does not leave the running config with the new settings. It is safe to
+// access concurrently.
+func (n *Network) SetConfig(cfg Config) (restore func()) {
+	c := &cfg
+	prev := n.config.Swap(c)
+	return func() {
+		n.config.CompareAndSwap(c, prev)
+	}
+}
+
+// Config gets the configuration.
+func (n *Network) Config() Config {
+	if cfg := n.config.Load(); cfg != nil {
+		return *cfg
+	}
+	return Config{}
+}
+
+// SetPacketConnMetrics sets the metrics of the packet connections of listeners
+// created afterwards. It is safe to access concurrently.
+func (n *Network) SetPacketConnMetrics(metrics *connmetrics.PacketConnMetrics) {
+	n.packetConnMetrics.Store(metrics)
+}
+
+// PacketConnMetrics gets the metrics of the packet connections. It returns
+// nil if none are set.
+func (n *Network) PacketConnMetrics() *connmetrics.PacketConnMetrics {
+	return n.packetConnMetrics.Load()
 }
 
 // Logger gets the logger.
@@ -98,25 +163,23 @@ func (n *Network) Listen(
 	if network != SCIONUDP {
 		return nil, fmt.Errorf("unsupported network: %s", network)
 	}
-	if strings.Contains(portRange, "-") {
-		return nil, fmt.Errorf("port ranges are not supported for SCION listeners, got: %s", portRange)
-	}
-	// PortOffset should be 0 for single ports, we fail if not.
-	if portOffset != 0 {
-		return nil, fmt.Errorf("port offsets are not supported for SCION UDP listeners")
+	address, err := listenaddr.JoinHostPort(withHostIP(host), portRange, portOffset)
+	if err != nil {
+		return nil, err
 	}
-	address := net.JoinHostPort(host, portRange)
 	laddr, err := snet.ParseUDPAddr(address)
 	if err != nil {
 		return nil, fmt.Errorf("parsing listening address: %w", err)
 	}
-	if laddr.Host.Port == 0 {
-		return nil, fmt.Errorf("wildcard port not supported: %s", address)
-	}
 
 	key := poolKey(network, laddr.String())
+	if laddr.Host.Port == 0 {
+		// A wildcard port binds a port from the end-host port range of the
+		// AS. Every such listener gets its own socket.
+		key = fmt.Sprintf("%s#%d", key, n.wildcards.Add(1))
+	}
 	c, loaded, err := n.Pool.LoadOrNew(key, func() (caddy.Destructor, error) {
-		return n.listener.listen(ctx, n, laddr, cfg)
+		return n.listener.listen(ctx, n, key, laddr, cfg)
 	})
 	if err != nil {
 		return nil, err
@@ -131,48 +194,115 @@ type listenerSCIONUDP struct {
 func (l *listenerSCIONUDP) listen(
 	ctx context.Context,
 	network *Network,
+	key string,
 	laddr *snet.UDPAddr,
 	cfg net.ListenConfig,
 ) (caddy.Destructor, error) {
+	var (
+		c   net.PacketConn
+		sd  *daemonConn
+		err error
+	)
+	if laddr.IA.IsZero() {
+		c, err = listenAllASes(ctx, network, laddr)
+	} else {
+		c, sd, err = open(ctx, network, laddr)
+	}
+	if err != nil {
+		network.Logger().Error("failed to listen on scion+udp", zap.Error(err))
+		return nil, err
+	}
+	return &conn{
+		PacketConn: newGuardConn(c, network),
+		key:        key,
+		addr:       laddr.String(),
+		network:    network,
+		daemon:     sd,
+	}, nil
+}
+
+// open opens the SCION socket for laddr. If the daemon is not reachable and
+// the network is configured to be lazy, a deferred connection is returned
+// instead, together with a nil daemon connection. Otherwise, the returned
+// daemon connection must be released once the socket is closed.
+func open(
+	ctx context.Context,
+	network *Network,
+	laddr *snet.UDPAddr,
+) (net.PacketConn, *daemonConn, error) {
+	c, sd, err := bind(ctx, network, laddr)
+	var unreachable *DaemonUnreachableError
+	if errors.As(err, &unreachable) && network.Config().Lazy {
+		network.Logger().Warn("SCION daemon not reachable, deferring listener",
+			zap.String("addr", laddr.String()), zap.Error(err))
+		return newDeferredConn(network, laddr), nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if laddr.Host.Port == 0 {
+		network.Logger().Info("bound scion+udp listener to wildcard port",
+			zap.String("addr", laddr.String()), zap.Stringer("local_addr", c.LocalAddr()))
+	} else {
+		network.Logger().Debug("created new scion+udp listener", zap.String("addr", laddr.String()))
+	}
+	return c, sd, nil
+}
+
+// bind opens the SCION socket for laddr. The returned daemon connection must
+// be released once the socket is closed.
+func bind(
+	ctx context.Context,
+	network *Network,
+	laddr *snet.UDPAddr,
+) (net.PacketConn, *daemonConn, error) {
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	sd, err := sciondConn(laddr.IA)
+	sd, err := network.sciondConn(laddr.IA)
 	if err != nil {
-		network.Logger().Error("failed to connect to SCIOND", zap.Error(err))
-		return nil, err
+		return nil, nil, err
 	}
 
 	n := &snet.SCIONNetwork{
 		Topology:          sd,
-		SCMPHandler:       ignoreSCMP{},
-		PacketConnMetrics: network.PacketConnMetrics,
+		SCMPHandler:       scmpHandler{network: network},
+		PacketConnMetrics: network.PacketConnMetrics().ForListener(SCIONUDP, laddr),
 	}
 
-	c, err := n.Listen(ctx, "udp", laddr.Host)
-	if err != nil {
-		network.Logger().Error("failed to listen on scion+udp", zap.Error(err))
-		return nil, err
+	host := laddr.Host
+	if host.IP == nil || host.IP.IsUnspecified() {
+		ip, err := localIP(ctx, sd, host.IP)
+		if err != nil {
+			sd.Close()
+			return nil, nil, err
+		}
+		network.Logger().Debug("selected local IP for listener",
+			zap.String("addr", laddr.String()), zap.Stringer("ip", ip))
+		host = &net.UDPAddr{IP: ip, Port: host.Port}
 	}
 
-	network.Logger().Debug("created new scion+udp listener", zap.String("addr", laddr.String()))
-	return &conn{
-		PacketConn: c,
-		addr:       laddr.String(),
-		network:    network,
-	}, nil
+	c, err := n.Listen(ctx, "udp", host)
+	if err != nil {
+		sd.Close()
+		return nil, nil, err
+	}
+	return newReplyConn(c, network, laddr.IA, sd), sd, nil
 }
 
 type conn struct {
 	net.PacketConn
+	key     string
 	addr    string
 	network *Network
+	daemon  *daemonConn
 }
 
 // Close removes the reference in the usage pool. If the references go to zero,
 // the connection is destroyed.
 func (c *conn) Close() error {
-	_, err := c.network.Pool.Delete(poolKey(SCIONUDP, c.addr))
+	_, err := c.network.Pool.Delete(c.key)
 	return err
 }
 
@@ -182,63 +312,39 @@ func (c *conn) Destruct() error {
 	c.network.Logger().Debug("destroying listener", zap.String("addr", c.addr))
 	defer c.network.Logger().Debug("destroyed listener", zap.String("addr", c.addr))
 
-	return c.PacketConn.Close()
-}
-
-// ignoreSCMP is a SCMP handler that ignores all SCMP messages. This is required
-// because SCMP error messages should not close the accept loop.
-type ignoreSCMP struct{}
-
-func (ignoreSCMP) Handle(pkt *snet.Packet) error {
-	// Always reattempt reads from the socket.
-	return nil
-}
-
-func poolKey(network string, address string) string {
-	return fmt.Sprintf("%s:%s", network, address)
-}
-
-// SCIONDConn returns a new connection to the SCION daemon of the specified ISD-AS,
-// using the SCION environment file.
-func sciondConn(ia addr.IA) (daemon.Connector, error) {
-	env, err := loadEnv()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading SCION environement: %v\n", err)
-		os.Exit(1)
+	err := c.PacketConn.Close()
+	if c.daemon == nil {
+		// Deferred and multi-AS connections release the daemon connections
+		// themselves.
+		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
-	defer cancel()
-	conn, err := findSciond(ctx, env, ia)
-	if err != nil {
-		return nil, err
+	if derr := c.daemon.Close(); err == nil {
+		err = derr
 	}
-	return conn, nil
+	return err
 }
 
-func findSciond(ctx context.Context, env env.SCION, ia addr.IA) (daemon.Connector, error) {
-	as, ok := env.ASes[ia]
-	if !ok {
-		return nil, fmt.Errorf("AS %v not found in environment", ia)
+// Ready reports whether the socket of the listener is bound. Only deferred
+// listeners can be not ready.
+func (c *conn) Ready() bool {
+	if r, ok := c.PacketConn.(interface{ Ready() bool }); ok {
+		return r.Ready()
 	}
-	sciondConn, err := daemon.NewService(as.DaemonAddress).Connect(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("unable to connect to AS %s SCIOND at %s: %w", ia, as.DaemonAddress, err)
-	}
-	return sciondConn, nil
+	return true
 }
 
-func loadEnv() (env.SCION, error) {
-	envFile := os.Getenv("SCION_ENV_FILE")
-	if envFile == "" {
-		envFile = "/etc/scion/environment.json"
-	}
-	raw, err := os.ReadFile(envFile)
-	if err != nil {
-		return env.SCION{}, err
-	}
-	var e env.SCION
-	if err := json.Unmarshal(raw, &e); err != nil {
-		return env.SCION{}, err
+// withHostIP completes SCION hosts without IP with the unspecified IPv4
+// address. Such a host is either a bare ISD-AS, e.g., 1-ff00:0:110, or an
+// ISD-AS followed by an empty IP, e.g., "1-ff00:0:110,". The actual IP is
+// selected when binding the socket.
+func withHostIP(host string) string {
+	ia, ip, found := strings.Cut(host, ",")
+	if !found || ip == "" {
+		return ia + ",0.0.0.0"
 	}
-	return e, nil
+	return host
+}
+
+func poolKey(network string, address string) string {
+	return fmt.Sprintf("%s:%s", network, address)
 }
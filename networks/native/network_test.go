@@ -0,0 +1,56 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"testing"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/pool"
+)
+
+func TestSetConfigRestore(t *testing.T) {
+	n := NewNetwork(pool.NewUsagePool[string, *conn]())
+	n.SetConfig(Config{EnvironmentFile: "running"})
+
+	// A config that fails to load restores the running one.
+	restore := n.SetConfig(Config{EnvironmentFile: "failed"})
+	restore()
+	if got := n.Config().EnvironmentFile; got != "running" {
+		t.Fatalf("after failed load: got %q, want %q", got, "running")
+	}
+
+	// The config replaced by a successful load does not restore its own
+	// settings when it is cleaned up.
+	restoreOld := n.SetConfig(Config{EnvironmentFile: "old"})
+	n.SetConfig(Config{EnvironmentFile: "new"})
+	restoreOld()
+	if got := n.Config().EnvironmentFile; got != "new" {
+		t.Fatalf("after cleanup of replaced config: got %q, want %q", got, "new")
+	}
+}
+
+func TestWithHostIP(t *testing.T) {
+	tests := map[string]string{
+		"1-ff00:0:110":          "1-ff00:0:110,0.0.0.0",
+		"1-ff00:0:110,":         "1-ff00:0:110,0.0.0.0",
+		"1-ff00:0:110,10.0.0.1": "1-ff00:0:110,10.0.0.1",
+		"1-ff00:0:110,::":       "1-ff00:0:110,::",
+	}
+	for host, want := range tests {
+		if got := withHostIP(host); got != want {
+			t.Errorf("withHostIP(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+// PathResolver looks up paths in the SCION daemons of the local ISD-ASes. It
+// keeps the daemon connections it used until it is closed, so that lookups do
+// not connect to the daemon every time. The connections are pooled, so a
+// resolver replacing another one on a config reload takes them over.
+type PathResolver struct {
+	network *Network
+
+	mu      sync.Mutex
+	daemons map[addr.IA]*daemonConn
+	closed  bool
+}
+
+// NewPathResolver creates a path resolver. It must be closed to release the
+// daemon connections.
+func (n *Network) NewPathResolver() *PathResolver {
+	return &PathResolver{
+		network: n,
+		daemons: make(map[addr.IA]*daemonConn),
+	}
+}
+
+// Paths returns the paths from src to dst known to the SCION daemon of src.
+// If src is the wildcard ISD-AS, the paths from all local ISD-ASes are
+// returned.
+func (r *PathResolver) Paths(ctx context.Context, dst, src addr.IA) ([]snet.Path, error) {
+	if !src.IsZero() {
+		return r.pathsFrom(ctx, dst, src)
+	}
+	ias, err := localIAs(r.network.Config())
+	if err != nil {
+		return nil, err
+	}
+	var paths []snet.Path
+	for _, ia := range ias {
+		p, err := r.pathsFrom(ctx, dst, ia)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p...)
+	}
+	return paths, nil
+}
+
+func (r *PathResolver) pathsFrom(ctx context.Context, dst, src addr.IA) ([]snet.Path, error) {
+	sd, err := r.daemon(src)
+	if err != nil {
+		return nil, err
+	}
+	return sd.Paths(ctx, dst, src, daemon.PathReqFlags{})
+}
+
+func (r *PathResolver) daemon(ia addr.IA) (*daemonConn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, errors.New("path resolver closed")
+	}
+	if sd, ok := r.daemons[ia]; ok {
+		return sd, nil
+	}
+	sd, err := r.network.sciondConn(ia)
+	if err != nil {
+		return nil, err
+	}
+	r.daemons[ia] = sd
+	return sd, nil
+}
+
+// Close releases the daemon connections.
+func (r *PathResolver) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	var errs []error
+	for ia, sd := range r.daemons {
+		errs = append(errs, sd.Close())
+		delete(r.daemons, ia)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/pool"
+)
+
+// closingDaemon is a pathsDaemon that can be closed.
+type closingDaemon struct {
+	pathsDaemon
+}
+
+func (closingDaemon) Close() error {
+	return nil
+}
+
+func TestPathResolverKeepsDaemon(t *testing.T) {
+	local := addr.MustParseIA("1-ff00:0:110")
+	remote := addr.MustParseIA("1-ff00:0:111")
+	paths := []snet.Path{
+		snetpath.Path{Src: local, Dst: remote, NextHop: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}},
+	}
+
+	n := NewNetwork(pool.NewUsagePool[string, *conn]())
+	n.SetLogger(zap.NewNop())
+	// Nothing listens on the daemon address, so a new connection fails.
+	n.SetConfig(Config{Daemons: map[addr.IA]string{local: "127.0.0.1:1"}})
+	key := daemonKey{ia: local, address: "127.0.0.1:1"}
+	sd, _, err := n.daemons.LoadOrNew(key, func() (caddy.Destructor, error) {
+		return newDaemonConn(n, key, closingDaemon{pathsDaemon{paths: paths}}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := n.NewPathResolver()
+	lookup := func() error {
+		t.Helper()
+		got, err := r.Paths(context.Background(), remote, local)
+		if err == nil && len(got) != len(paths) {
+			t.Errorf("got %d paths, want %d", len(got), len(paths))
+		}
+		return err
+	}
+	if err := lookup(); err != nil {
+		t.Fatal(err)
+	}
+	// The resolver keeps the connection after the listener released it.
+	if err := sd.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lookup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	n.daemons.Range(func(k daemonKey, _ *daemonConn) bool {
+		t.Errorf("daemon connection %v not released", k)
+		return true
+	})
+	if err := lookup(); err == nil {
+		t.Error("lookup succeeded after close")
+	}
+}
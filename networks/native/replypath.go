@@ -0,0 +1,301 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/segment/iface"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/scionproto/scion/private/path/pathpol"
+	"go.uber.org/zap"
+)
+
+var (
+	_ net.PacketConn = (*replyConn)(nil)
+
+	_ ReplyPathSelector = LowestLatency{}
+	_ ReplyPathSelector = PolicyPath{}
+)
+
+const (
+	// noPathRetry is the time after which the paths to a remote are looked
+	// up again if no path was selected.
+	noPathRetry = 10 * time.Second
+	// maxReplyPaths is the number of cached paths above which expired ones
+	// are pruned.
+	maxReplyPaths = 1024
+	// revokedTTL is the time during which paths over an interface signaled
+	// down by SCMP are not selected.
+	revokedTTL = 10 * time.Second
+)
+
+// ReplyPathSelector selects the path replies to a remote ISD-AS are sent on,
+// instead of the reversed incoming path.
+type ReplyPathSelector interface {
+	// SelectPath returns one of paths, or nil to reply on the reversed
+	// incoming path.
+	SelectPath(paths []snet.Path) snet.Path
+}
+
+// Reply path modes accepted by NewReplyPathSelector.
+const (
+	ReplyPathReverse = "reverse"
+	ReplyPathLatency = "latency"
+	ReplyPathPolicy  = "policy"
+)
+
+// NewReplyPathSelector returns the selector for mode. The policy is only used,
+// and required, by the "policy" mode. The "reverse" mode, as well as the empty
+// mode, return a nil selector.
+func NewReplyPathSelector(mode string, policy *pathpol.Policy) (ReplyPathSelector, error) {
+	switch mode {
+	case "", ReplyPathReverse:
+		return nil, nil
+	case ReplyPathLatency:
+		return LowestLatency{}, nil
+	case ReplyPathPolicy:
+		if policy == nil {
+			return nil, fmt.Errorf("reply path mode %q requires a policy", mode)
+		}
+		return PolicyPath{Policy: policy}, nil
+	default:
+		return nil, fmt.Errorf("unknown reply path mode %q", mode)
+	}
+}
+
+// LowestLatency selects the path with the lowest latency announced by the
+// ASes. Paths with unknown latency are only selected if no other path is
+// available.
+type LowestLatency struct{}
+
+func (LowestLatency) SelectPath(paths []snet.Path) snet.Path {
+	var (
+		best        snet.Path
+		bestLatency time.Duration
+		bestKnown   bool
+	)
+	for _, p := range paths {
+		latency, known := totalLatency(p.Metadata())
+		if best == nil || (known && (!bestKnown || latency < bestLatency)) {
+			best, bestLatency, bestKnown = p, latency, known
+		}
+	}
+	return best
+}
+
+func totalLatency(md *snet.PathMetadata) (time.Duration, bool) {
+	if md == nil || len(md.Latency) == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	for _, l := range md.Latency {
+		if l == snet.LatencyUnset {
+			return 0, false
+		}
+		total += l
+	}
+	return total, true
+}
+
+// PolicyPath selects the first path allowed by the policy.
+type PolicyPath struct {
+	Policy *pathpol.Policy
+}
+
+func (s PolicyPath) SelectPath(paths []snet.Path) snet.Path {
+	if allowed := s.Policy.Filter(paths); len(allowed) > 0 {
+		return allowed[0]
+	}
+	return nil
+}
+
+// replyConn sends packets on the path chosen by the selector of the current
+// network config instead of the path of the destination address. The
+// selector is looked up on every write, so that a config reload applies to
+// the listeners kept across it. The selected path is cached per remote ISD-AS
+// until it expires, until the config changes, or until an interface on it is
+// revoked.
+type replyConn struct {
+	net.PacketConn
+	local       addr.IA
+	daemon      daemon.Connector
+	network     *Network
+	unsubscribe func()
+
+	mu sync.Mutex
+	// config is the config the cached paths were selected with.
+	config  *Config
+	paths   map[addr.IA]selectedPath
+	pending map[addr.IA]struct{}
+	// revoked holds the expiry of the revoked interfaces.
+	revoked map[snet.PathInterface]time.Time
+}
+
+type selectedPath struct {
+	path   snet.Path
+	expiry time.Time
+}
+
+func newReplyConn(
+	c net.PacketConn,
+	network *Network,
+	local addr.IA,
+	sd daemon.Connector,
+) *replyConn {
+	rc := &replyConn{
+		PacketConn: c,
+		local:      local,
+		daemon:     sd,
+		network:    network,
+		paths:      make(map[addr.IA]selectedPath),
+		pending:    make(map[addr.IA]struct{}),
+		revoked:    make(map[snet.PathInterface]time.Time),
+	}
+	rc.unsubscribe = network.SubscribeRevocations(rc.revoke)
+	return rc
+}
+
+func (c *replyConn) Close() error {
+	c.unsubscribe()
+	return c.PacketConn.Close()
+}
+
+// revoke evicts the cached paths over the interface of ev, and excludes the
+// interface from the paths selected during the next revokedTTL.
+func (c *replyConn) revoke(ev RevocationEvent) {
+	intf := snet.PathInterface{IA: ev.IA, ID: iface.ID(ev.Interface)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revoked[intf] = time.Now().Add(revokedTTL)
+	for ia, s := range c.paths {
+		if s.path != nil && slices.Contains(interfaces(s.path), intf) {
+			delete(c.paths, ia)
+		}
+	}
+}
+
+// usable returns the paths that do not traverse a revoked interface.
+func (c *replyConn) usable(paths []snet.Path) []snet.Path {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for intf, expiry := range c.revoked {
+		if !now.Before(expiry) {
+			delete(c.revoked, intf)
+		}
+	}
+	if len(c.revoked) == 0 {
+		return paths
+	}
+	var usable []snet.Path
+	for _, p := range paths {
+		if !slices.ContainsFunc(interfaces(p), func(intf snet.PathInterface) bool {
+			_, ok := c.revoked[intf]
+			return ok
+		}) {
+			usable = append(usable, p)
+		}
+	}
+	return usable
+}
+
+func interfaces(p snet.Path) []snet.PathInterface {
+	if md := p.Metadata(); md != nil {
+		return md.Interfaces
+	}
+	return nil
+}
+
+func (c *replyConn) WriteTo(b []byte, a net.Addr) (int, error) {
+	dst, ok := a.(*snet.UDPAddr)
+	if !ok || dst.IA == c.local {
+		return c.PacketConn.WriteTo(b, a)
+	}
+	cfg := c.network.config.Load()
+	if cfg == nil || cfg.ReplyPath == nil {
+		return c.PacketConn.WriteTo(b, a)
+	}
+	if p := c.path(cfg, dst.IA); p != nil {
+		dst = dst.Copy()
+		dst.Path = p.Dataplane()
+		dst.NextHop = p.UnderlayNextHop()
+		a = dst
+	}
+	return c.PacketConn.WriteTo(b, a)
+}
+
+// path returns the path to ia selected with cfg, or nil if the reversed
+// incoming path is to be used. Missing or expired paths are looked up in the
+// background, in the meantime the reversed incoming path is used.
+func (c *replyConn) path(cfg *Config, ia addr.IA) snet.Path {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cfg != c.config {
+		// The cached paths were selected with the settings of another config.
+		c.config = cfg
+		clear(c.paths)
+	}
+	s, ok := c.paths[ia]
+	if ok && now.Before(s.expiry) {
+		return s.path
+	}
+	if _, ok := c.pending[ia]; !ok {
+		c.pending[ia] = struct{}{}
+		go c.lookup(cfg, ia)
+	}
+	return nil
+}
+
+func (c *replyConn) lookup(cfg *Config, ia addr.IA) {
+	now := time.Now()
+	s := selectedPath{expiry: now.Add(noPathRetry)}
+	ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+	defer cancel()
+	paths, err := c.daemon.Paths(ctx, ia, c.local, daemon.PathReqFlags{})
+	if err != nil {
+		c.network.Logger().Debug("failed to look up reply paths",
+			zap.Stringer("remote", ia), zap.Error(err))
+	} else if p := cfg.ReplyPath.SelectPath(c.usable(paths)); p != nil {
+		s.path = p
+		if md := p.Metadata(); md != nil && !md.Expiry.IsZero() {
+			s.expiry = md.Expiry
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, ia)
+	if cfg != c.config {
+		// The config changed during the lookup.
+		return
+	}
+	if len(c.paths) >= maxReplyPaths {
+		for k, e := range c.paths {
+			if !now.Before(e.expiry) {
+				delete(c.paths, k)
+			}
+		}
+	}
+	c.paths[ia] = s
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/pool"
+)
+
+// pathsDaemon returns the same paths for every remote.
+type pathsDaemon struct {
+	daemon.Connector
+	paths []snet.Path
+}
+
+func (d pathsDaemon) Paths(context.Context, addr.IA, addr.IA, daemon.PathReqFlags) ([]snet.Path, error) {
+	return d.paths, nil
+}
+
+// recordingConn records the destinations of the written packets.
+type recordingConn struct {
+	net.PacketConn
+	mu     sync.Mutex
+	writes []*snet.UDPAddr
+}
+
+func (c *recordingConn) WriteTo(b []byte, a net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, a.(*snet.UDPAddr))
+	return len(b), nil
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) last() *snet.UDPAddr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes[len(c.writes)-1]
+}
+
+// nthPath selects the n-th path.
+type nthPath int
+
+func (n nthPath) SelectPath(paths []snet.Path) snet.Path {
+	return paths[n]
+}
+
+func TestReplyConnFollowsConfig(t *testing.T) {
+	local := addr.MustParseIA("1-ff00:0:110")
+	remote := addr.MustParseIA("1-ff00:0:111")
+	paths := []snet.Path{
+		snetpath.Path{Src: local, Dst: remote, NextHop: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}},
+		snetpath.Path{Src: local, Dst: remote, NextHop: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 2}},
+	}
+	incoming := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 9}
+	dst := &snet.UDPAddr{IA: remote, Host: &net.UDPAddr{IP: net.IPv4(10, 1, 0, 1), Port: 443}, NextHop: incoming}
+
+	network := NewNetwork(pool.NewUsagePool[string, *conn]())
+	network.SetLogger(zap.NewNop())
+	rec := &recordingConn{}
+	c := newReplyConn(rec, network, local, pathsDaemon{paths: paths})
+
+	// writeUntil writes until the packet is sent to the next hop, or fails
+	// the test if the path is not selected in time.
+	writeUntil := func(nextHop *net.UDPAddr) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := c.WriteTo([]byte("x"), dst); err != nil {
+				t.Fatal(err)
+			}
+			if rec.last().NextHop.String() == nextHop.String() {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("packets not sent to %s, last sent to %s", nextHop, rec.last().NextHop)
+	}
+
+	// Without a selector, the reversed incoming path is used.
+	writeUntil(incoming)
+
+	network.SetConfig(Config{ReplyPath: nthPath(0)})
+	writeUntil(paths[0].UnderlayNextHop())
+
+	// The selector of a reloaded config replaces the cached path.
+	network.SetConfig(Config{ReplyPath: nthPath(1)})
+	writeUntil(paths[1].UnderlayNextHop())
+
+	network.SetConfig(Config{})
+	writeUntil(incoming)
+}
+
+func TestReplyConnEvictsRevokedPaths(t *testing.T) {
+	local := addr.MustParseIA("1-ff00:0:110")
+	remote := addr.MustParseIA("1-ff00:0:111")
+	revoked := snet.PathInterface{IA: local, ID: 1}
+	paths := []snet.Path{
+		snetpath.Path{
+			Src: local, Dst: remote,
+			NextHop: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1},
+			Meta: snet.PathMetadata{Interfaces: []snet.PathInterface{
+				revoked, {IA: remote, ID: 1},
+			}},
+		},
+		snetpath.Path{
+			Src: local, Dst: remote,
+			NextHop: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 2},
+			Meta: snet.PathMetadata{Interfaces: []snet.PathInterface{
+				{IA: local, ID: 2}, {IA: remote, ID: 2},
+			}},
+		},
+	}
+	dst := &snet.UDPAddr{IA: remote, Host: &net.UDPAddr{IP: net.IPv4(10, 1, 0, 1), Port: 443}}
+
+	network := NewNetwork(pool.NewUsagePool[string, *conn]())
+	network.SetLogger(zap.NewNop())
+	network.SetConfig(Config{ReplyPath: nthPath(0)})
+	rec := &recordingConn{}
+	c := newReplyConn(rec, network, local, pathsDaemon{paths: paths})
+
+	writeUntil := func(nextHop *net.UDPAddr) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := c.WriteTo([]byte("x"), dst); err != nil {
+				t.Fatal(err)
+			}
+			if last := rec.last().NextHop; last != nil && last.String() == nextHop.String() {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("packets not sent to %s, last sent to %s", nextHop, rec.last().NextHop)
+	}
+
+	writeUntil(paths[0].UnderlayNextHop())
+
+	// The path over the revoked interface is evicted and not selected again.
+	network.revocations.publish(RevocationEvent{IA: revoked.IA, Interface: uint64(revoked.ID)})
+	writeUntil(paths[1].UnderlayNextHop())
+
+	// Closed listeners no longer receive the revocations.
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	network.revocations.mu.RLock()
+	defer network.revocations.mu.RUnlock()
+	if n := len(network.revocations.subs); n != 0 {
+		t.Errorf("got %d subscribers, want 0", n)
+	}
+}
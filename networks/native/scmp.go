@@ -0,0 +1,180 @@
+// Copyright 2024 Anapaya Systems, ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/snet"
+	"go.uber.org/zap"
+)
+
+var (
+	_ snet.SCMPHandler = (*scmpHandler)(nil)
+)
+
+// SCMPMetrics are the metrics recorded for the SCMP messages received by the
+// listeners of the network.
+type SCMPMetrics struct {
+	// Messages counts the SCMP messages by type and code.
+	Messages *prometheus.CounterVec
+}
+
+// NewSCMPMetrics creates the SCMP metrics. They are not registered.
+func NewSCMPMetrics() SCMPMetrics {
+	return SCMPMetrics{
+		Messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "caddy",
+			Subsystem: "scion",
+			Name:      "scmp_messages_total",
+			Help:      "Total number of SCMP messages received by the SCION listeners.",
+		}, []string{"type", "code"}),
+	}
+}
+
+// Collectors returns the collectors to be registered.
+func (m SCMPMetrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.Messages}
+}
+
+// RevocationEvent is published to the subscribers of the network when an SCMP
+// message signals that an interface is down.
+type RevocationEvent struct {
+	// Type is either slayers.SCMPTypeExternalInterfaceDown or
+	// slayers.SCMPTypeInternalConnectivityDown.
+	Type slayers.SCMPType
+	// IA is the ISD-AS of the router that originated the message.
+	IA addr.IA
+	// Interface is the ID of the interface that is down. For internal
+	// connectivity down messages, it is the egress interface.
+	Interface uint64
+	// Source is the address the message was received from.
+	Source snet.SCIONAddress
+}
+
+// revocationSubscribers holds the callbacks subscribed to revocation events.
+type revocationSubscribers struct {
+	mu     sync.RWMutex
+	nextID uint64
+	subs   map[uint64]func(RevocationEvent)
+}
+
+func (s *revocationSubscribers) subscribe(f func(RevocationEvent)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = make(map[uint64]func(RevocationEvent))
+	}
+	id := s.nextID
+	s.nextID++
+	s.subs[id] = f
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subs, id)
+	}
+}
+
+func (s *revocationSubscribers) publish(ev RevocationEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.subs {
+		f(ev)
+	}
+}
+
+// SubscribeRevocations registers f to be called for every revocation received
+// by the listeners of the network. f is called from the read loop of the
+// listener, so it must not block. The returned function removes the
+// subscription. The listeners subscribe themselves to evict the reply paths
+// over revoked interfaces.
+func (n *Network) SubscribeRevocations(f func(RevocationEvent)) func() {
+	return n.revocations.subscribe(f)
+}
+
+// SetSCMPHandler sets an additional handler that is called for every SCMP
+// message received by the listeners of the network. It is looked up per
+// message, so it also applies to the listeners kept across config reloads.
+// Errors returned by the handler are logged and do not end the read loop. It
+// is safe to access concurrently.
+func (n *Network) SetSCMPHandler(h snet.SCMPHandler) {
+	n.scmpHandler.Store(&h)
+}
+
+// SetSCMPMetrics sets the SCMP metrics. It is safe to access concurrently.
+func (n *Network) SetSCMPMetrics(metrics SCMPMetrics) {
+	n.scmpMetrics.Store(&metrics)
+}
+
+// SCMPMetrics gets the SCMP metrics. The collectors are nil if none are set.
+func (n *Network) SCMPMetrics() SCMPMetrics {
+	if m := n.scmpMetrics.Load(); m != nil {
+		return *m
+	}
+	return SCMPMetrics{}
+}
+
+// scmpHandler records the SCMP messages and notifies the revocation
+// subscribers. It always returns nil, because SCMP error messages should not
+// close the accept loop.
+type scmpHandler struct {
+	network *Network
+}
+
+func (h scmpHandler) Handle(pkt *snet.Packet) error {
+	scmp, ok := pkt.Payload.(snet.SCMPPayload)
+	if !ok {
+		return nil
+	}
+	typeCode := slayers.CreateSCMPTypeCode(scmp.Type(), scmp.Code())
+	if m := h.network.SCMPMetrics().Messages; m != nil {
+		m.WithLabelValues(
+			strconv.Itoa(int(scmp.Type())),
+			strconv.Itoa(int(scmp.Code())),
+		).Inc()
+	}
+	h.network.Logger().Debug("received SCMP message",
+		zap.Stringer("scmp", typeCode), zap.Stringer("src", pkt.Source))
+
+	switch msg := pkt.Payload.(type) {
+	case snet.SCMPExternalInterfaceDown:
+		h.network.revocations.publish(RevocationEvent{
+			Type:      scmp.Type(),
+			IA:        msg.IA,
+			Interface: msg.Interface,
+			Source:    pkt.Source,
+		})
+	case snet.SCMPInternalConnectivityDown:
+		h.network.revocations.publish(RevocationEvent{
+			Type:      scmp.Type(),
+			IA:        msg.IA,
+			Interface: msg.Egress,
+			Source:    pkt.Source,
+		})
+	}
+
+	if custom := h.network.scmpHandler.Load(); custom != nil && *custom != nil {
+		if err := (*custom).Handle(pkt); err != nil {
+			h.network.Logger().Debug("SCMP handler failed",
+				zap.Stringer("scmp", typeCode), zap.Error(err))
+		}
+	}
+	// Always reattempt reads from the socket.
+	return nil
+}
@@ -44,6 +44,14 @@ func (p *UsagePool[K, V]) LoadOrNew(key K, construct func() (caddy.Destructor, e
 	return v.(V), l, nil
 }
 
+// Range iterates over the pool, stopping if f returns false. See
+// caddy.UsagePool.Range.
+func (p *UsagePool[K, V]) Range(f func(key K, value V) bool) {
+	p.pool.Range(func(key, value any) bool {
+		return f(key.(K), value.(V))
+	})
+}
+
 func (p *UsagePool[K, T]) Delete(key K) (bool, error) {
 	return p.pool.Delete(key)
 }
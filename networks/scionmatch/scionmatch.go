@@ -0,0 +1,176 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scionmatch matches SCION addresses by ISD, AS and host prefix. It is
+// shared by the matchers of the HTTP and layer4 apps.
+package scionmatch
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+// Filter matches SCION addresses. Every list holds entries that include the
+// matching values; entries prefixed with "!" exclude them instead. A list
+// matches a value if no exclusion matches it and either an inclusion matches
+// it or the list has no inclusions. The filter matches an address if all its
+// lists match.
+type Filter struct {
+	// ISDs are single ISDs ("1") or inclusive ranges ("1-3").
+	ISDs []string `json:"isds,omitempty"`
+	// ASes are single ASes ("ff00:0:110") or inclusive ranges
+	// ("ff00:0:110-ff00:0:120").
+	ASes []string `json:"ases,omitempty"`
+	// Hosts are host IP prefixes ("10.0.0.0/8") or single IPs.
+	Hosts []string `json:"hosts,omitempty"`
+
+	isds  list[addr.ISD]
+	ases  list[addr.AS]
+	hosts list[netip.Addr]
+}
+
+// Provision parses the entries of the filter.
+func (f *Filter) Provision() error {
+	var err error
+	if f.isds, err = parseList(f.ISDs, func(s string) (rangeOf[addr.ISD], error) {
+		return parseRange(s, addr.ParseISD)
+	}); err != nil {
+		return fmt.Errorf("parsing ISDs: %w", err)
+	}
+	if f.ases, err = parseList(f.ASes, func(s string) (rangeOf[addr.AS], error) {
+		return parseRange(s, addr.ParseAS)
+	}); err != nil {
+		return fmt.Errorf("parsing ASes: %w", err)
+	}
+	if f.hosts, err = parseList(f.Hosts, parsePrefix); err != nil {
+		return fmt.Errorf("parsing hosts: %w", err)
+	}
+	return nil
+}
+
+// Empty reports whether the filter has no entries, i.e., it matches every
+// SCION address.
+func (f *Filter) Empty() bool {
+	return len(f.ISDs) == 0 && len(f.ASes) == 0 && len(f.Hosts) == 0
+}
+
+// Match reports whether a matches the filter. The filter has to be
+// provisioned.
+func (f *Filter) Match(a *snet.UDPAddr) bool {
+	if !f.isds.match(a.IA.ISD()) || !f.ases.match(a.IA.AS()) {
+		return false
+	}
+	if len(f.Hosts) == 0 {
+		return true
+	}
+	if a.Host == nil {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(a.Host.IP)
+	return ok && f.hosts.match(ip.Unmap())
+}
+
+type matcher[T any] interface {
+	contains(T) bool
+}
+
+type entry[T any] struct {
+	matcher[T]
+	exclude bool
+}
+
+type list[T any] []entry[T]
+
+func (l list[T]) match(v T) bool {
+	included, hasInclusions := false, false
+	for _, e := range l {
+		if !e.contains(v) {
+			if !e.exclude {
+				hasInclusions = true
+			}
+			continue
+		}
+		if e.exclude {
+			return false
+		}
+		included, hasInclusions = true, true
+	}
+	return included || !hasInclusions
+}
+
+func parseList[T any, M matcher[T]](entries []string, parse func(string) (M, error)) (list[T], error) {
+	l := make(list[T], 0, len(entries))
+	for _, s := range entries {
+		exclude := strings.HasPrefix(s, "!")
+		m, err := parse(strings.TrimPrefix(s, "!"))
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, entry[T]{matcher: m, exclude: exclude})
+	}
+	return l, nil
+}
+
+type rangeOf[T addr.ISD | addr.AS] struct {
+	min, max T
+}
+
+func (r rangeOf[T]) contains(v T) bool {
+	return r.min <= v && v <= r.max
+}
+
+func parseRange[T addr.ISD | addr.AS](s string, parse func(string) (T, error)) (rangeOf[T], error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	min, err := parse(lo)
+	if err != nil {
+		return rangeOf[T]{}, err
+	}
+	if !isRange {
+		return rangeOf[T]{min: min, max: min}, nil
+	}
+	max, err := parse(hi)
+	if err != nil {
+		return rangeOf[T]{}, err
+	}
+	if max < min {
+		return rangeOf[T]{}, fmt.Errorf("invalid range %q", s)
+	}
+	return rangeOf[T]{min: min, max: max}, nil
+}
+
+type prefix netip.Prefix
+
+func (p prefix) contains(ip netip.Addr) bool {
+	return netip.Prefix(p).Contains(ip)
+}
+
+func parsePrefix(s string) (prefix, error) {
+	if !strings.Contains(s, "/") {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return prefix{}, err
+		}
+		ip = ip.Unmap()
+		return prefix(netip.PrefixFrom(ip, ip.BitLen())), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return prefix{}, err
+	}
+	return prefix(p.Masked()), nil
+}
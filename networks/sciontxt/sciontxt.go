@@ -0,0 +1,231 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sciontxt resolves names to SCION addresses published in DNS TXT
+// records of the form "scion=<isd-as>,<ip>", falling back to A/AAAA records
+// unless disabled.
+// Unlike the resolver of the standard library, it reports the TTL of the
+// records, so that the results can be cached accordingly.
+package sciontxt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/scionproto/scion/pkg/addr"
+)
+
+const (
+	txtTag = "scion="
+
+	resolvConf     = "/etc/resolv.conf"
+	defaultTimeout = 5 * time.Second
+)
+
+// Host is a resolved host. IA is zero for hosts resolved from A/AAAA
+// records, which are only reachable over IP.
+type Host struct {
+	IA addr.IA
+	IP netip.Addr
+}
+
+// JoinHostPort returns the dial address of the host, i.e., [isd-as,ip]:port
+// for SCION hosts and ip:port otherwise.
+func (h Host) JoinHostPort(port string) string {
+	if h.IA.IsZero() {
+		return net.JoinHostPort(h.IP.String(), port)
+	}
+	return net.JoinHostPort(fmt.Sprintf("%s,%s", h.IA, h.IP), port)
+}
+
+// Result is the result of a lookup.
+type Result struct {
+	Hosts []Host
+	// TTL is the lowest TTL of the records the hosts were taken from.
+	TTL time.Duration
+}
+
+// Resolver queries DNS servers directly, so that the TTL of the records is
+// available.
+type Resolver struct {
+	// Servers are the addresses, i.e., host:port, of the DNS servers to
+	// query. One is picked at random for every query. If empty, the
+	// nameservers in /etc/resolv.conf are used.
+	Servers []string
+	// Timeout of a single query. Default: 5s.
+	Timeout time.Duration
+	// NoIPFallback disables the fallback to A/AAAA records, so that only
+	// SCION hosts are returned.
+	NoIPFallback bool
+}
+
+// Lookup resolves name. SCION TXT records take precedence; A and AAAA
+// records are only consulted if there is no valid SCION TXT record and the
+// fallback is enabled. The hosts of a result are therefore either all SCION
+// hosts or all IP hosts.
+func (r *Resolver) Lookup(ctx context.Context, name string) (Result, error) {
+	servers, err := r.servers()
+	if err != nil {
+		return Result{}, err
+	}
+	name = dns.Fqdn(name)
+
+	txt, err := r.query(ctx, servers, name, dns.TypeTXT)
+	if err != nil {
+		return Result{}, err
+	}
+	if res := scionHosts(txt); len(res.Hosts) > 0 {
+		return res, nil
+	}
+	if r.NoIPFallback {
+		return Result{}, &net.DNSError{Err: "no SCION address", Name: name, IsNotFound: true}
+	}
+
+	var res Result
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rrs, err := r.query(ctx, servers, name, qtype)
+		if err != nil {
+			return Result{}, err
+		}
+		for _, rr := range rrs {
+			var ip net.IP
+			switch rr := rr.(type) {
+			case *dns.A:
+				ip = rr.A
+			case *dns.AAAA:
+				ip = rr.AAAA
+			default:
+				continue
+			}
+			a, ok := netip.AddrFromSlice(ip)
+			if !ok {
+				continue
+			}
+			res.add(Host{IP: a.Unmap()}, rr.Header().Ttl)
+		}
+	}
+	if len(res.Hosts) == 0 {
+		return Result{}, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return res, nil
+}
+
+func (r *Resolver) servers() ([]string, error) {
+	if len(r.Servers) > 0 {
+		return r.Servers, nil
+	}
+	cfg, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil {
+		return nil, fmt.Errorf("loading DNS servers: %w", err)
+	}
+	servers := make([]string, 0, len(cfg.Servers))
+	for _, s := range cfg.Servers {
+		servers = append(servers, net.JoinHostPort(s, cfg.Port))
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no DNS servers configured")
+	}
+	return servers, nil
+}
+
+// query returns the answer records of the given type. A non-existent name is
+// not an error; no records are returned instead.
+func (r *Resolver) query(
+	ctx context.Context,
+	servers []string,
+	name string,
+	qtype uint16,
+) ([]dns.RR, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	c := new(dns.Client)
+	server := servers[rand.IntN(len(servers))]
+	in, _, err := c.ExchangeContext(ctx, m, server)
+	if err == nil && in.Truncated {
+		c.Net = "tcp"
+		in, _, err = c.ExchangeContext(ctx, m, server)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying %s for %s %s: %w",
+			server, name, dns.TypeToString[qtype], err)
+	}
+	switch in.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+		return in.Answer, nil
+	default:
+		return nil, fmt.Errorf("querying %s for %s %s: %s",
+			server, name, dns.TypeToString[qtype], dns.RcodeToString[in.Rcode])
+	}
+}
+
+func scionHosts(rrs []dns.RR) Result {
+	var res Result
+	for _, rr := range rrs {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		for _, s := range txt.Txt {
+			h, ok := parseTXT(s)
+			if !ok {
+				continue
+			}
+			res.add(h, txt.Hdr.Ttl)
+		}
+	}
+	return res
+}
+
+// parseTXT parses "scion=<isd-as>,<ip>". The IP may be enclosed in brackets.
+func parseTXT(s string) (Host, bool) {
+	v, ok := strings.CutPrefix(s, txtTag)
+	if !ok {
+		return Host{}, false
+	}
+	rawIA, rawIP, ok := strings.Cut(v, ",")
+	if !ok {
+		return Host{}, false
+	}
+	ia, err := addr.ParseIA(rawIA)
+	if err != nil {
+		return Host{}, false
+	}
+	ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(rawIP, "["), "]"))
+	if err != nil {
+		return Host{}, false
+	}
+	return Host{IA: ia, IP: ip.Unmap()}, true
+}
+
+func (r *Result) add(h Host, ttl uint32) {
+	d := time.Duration(ttl) * time.Second
+	if len(r.Hosts) == 0 || d < r.TTL {
+		r.TTL = d
+	}
+	r.Hosts = append(r.Hosts, h)
+}
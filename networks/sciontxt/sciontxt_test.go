@@ -0,0 +1,189 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sciontxt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// zone maps names to their records, in zone file syntax.
+type zone map[string][]string
+
+// stubServer serves the zone over UDP on a local port and returns its
+// address. Names outside of the zone are answered with NXDOMAIN, and names
+// without records with SERVFAIL.
+func stubServer(t *testing.T, z zone) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			q := req.Question[0]
+			records, ok := z[q.Name]
+			switch {
+			case !ok:
+				m.Rcode = dns.RcodeNameError
+			case len(records) == 0:
+				m.Rcode = dns.RcodeServerFailure
+			}
+			for _, s := range records {
+				rr, err := dns.NewRR(s)
+				if err != nil {
+					t.Errorf("parsing %q: %v", s, err)
+					continue
+				}
+				if rr.Header().Rrtype == q.Qtype {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+			_ = w.WriteMsg(m)
+		}),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+	return pc.LocalAddr().String()
+}
+
+func TestLookup(t *testing.T) {
+	server := stubServer(t, zone{
+		"scion.example.": {
+			`scion.example. 300 IN TXT "scion=1-ff00:0:110,10.0.0.1"`,
+			`scion.example. 60 IN TXT "scion=1-ff00:0:111,[fd00::1]"`,
+			`scion.example. 10 IN TXT "v=spf1 -all"`,
+			`scion.example. 30 IN A 192.0.2.1`,
+		},
+		"malformed.example.": {
+			`malformed.example. 10 IN TXT "scion=1-ff00:0:110"`,
+			`malformed.example. 10 IN TXT "scion=1-ff00:0:110,not-an-ip"`,
+			`malformed.example. 10 IN TXT "scion=not-an-ia,10.0.0.1"`,
+			`malformed.example. 120 IN A 192.0.2.1`,
+			`malformed.example. 90 IN AAAA ::ffff:192.0.2.2`,
+			`malformed.example. 100 IN AAAA 2001:db8::1`,
+		},
+		"empty.example.": {
+			`empty.example. 10 IN TXT "nothing here"`,
+		},
+		"broken.example.": {},
+	})
+
+	tests := map[string]struct {
+		name         string
+		noIPFallback bool
+		want         []string
+		wantTTL      time.Duration
+		notFound     bool
+		wantErr      bool
+	}{
+		"scion records": {
+			name:    "scion.example",
+			want:    []string{"[1-ff00:0:110,10.0.0.1]:443", "[1-ff00:0:111,fd00::1]:443"},
+			wantTTL: 60 * time.Second,
+		},
+		"fallback to A/AAAA records": {
+			name:    "malformed.example",
+			want:    []string{"192.0.2.1:443", "192.0.2.2:443", "[2001:db8::1]:443"},
+			wantTTL: 90 * time.Second,
+		},
+		"fallback disabled": {
+			name:         "malformed.example",
+			noIPFallback: true,
+			notFound:     true,
+		},
+		"scion records without fallback": {
+			name:         "scion.example",
+			noIPFallback: true,
+			want:         []string{"[1-ff00:0:110,10.0.0.1]:443", "[1-ff00:0:111,fd00::1]:443"},
+			wantTTL:      60 * time.Second,
+		},
+		"no addresses": {
+			name:     "empty.example",
+			notFound: true,
+		},
+		"non-existent name": {
+			name:     "missing.example",
+			notFound: true,
+		},
+		"server failure": {
+			name:    "broken.example",
+			wantErr: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &Resolver{Servers: []string{server}, NoIPFallback: tc.noIPFallback}
+			res, err := r.Lookup(context.Background(), tc.name)
+			var dnsErr *net.DNSError
+			switch {
+			case tc.notFound:
+				if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+					t.Fatalf("got %v, want a not found error", err)
+				}
+				return
+			case tc.wantErr:
+				if err == nil || errors.As(err, &dnsErr) {
+					t.Fatalf("got %v, want a query error", err)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, h := range res.Hosts {
+				got = append(got, h.JoinHostPort("443"))
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got hosts %v, want %v", got, tc.want)
+			}
+			if res.TTL != tc.wantTTL {
+				t.Errorf("got TTL %v, want %v", res.TTL, tc.wantTTL)
+			}
+		})
+	}
+}
+
+func TestParseTXT(t *testing.T) {
+	tests := map[string]bool{
+		"scion=1-ff00:0:110,10.0.0.1":    true,
+		"scion=1-ff00:0:110,[fd00::1]":   true,
+		"scion=1-ff00:0:110,fd00::1":     true,
+		"scion=1-ff00:0:110":             false,
+		"scion=1-ff00:0:110,":            false,
+		"scion=,10.0.0.1":                false,
+		"scion=1-ff00:0:110,10.0.0.1:80": false,
+		"SCION=1-ff00:0:110,10.0.0.1":    false,
+		"v=spf1 -all":                    false,
+	}
+	for s, want := range tests {
+		if _, ok := parseTXT(s); ok != want {
+			t.Errorf("parseTXT(%q) valid = %v, want %v", s, ok, want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scionupstream contains the helpers shared by the modules that dial
+// upstreams over SCION.
+package scionupstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsec-ethz/scion-apps/pkg/quicutil"
+	"github.com/quic-go/quic-go"
+)
+
+// PathPolicy restricts the paths used to reach the upstreams. An empty policy
+// allows every path.
+type PathPolicy struct {
+	// ACL entries, e.g., ["- 1-ff00:0:110#0", "+"].
+	ACL []string `json:"acl,omitempty"`
+
+	// Sequence of hop predicates, e.g., "1-ff00:0:110 0* 1-ff00:0:111".
+	Sequence string `json:"sequence,omitempty"`
+}
+
+// Build returns the policy, or nil if it is empty.
+func (p PathPolicy) Build() (pan.Policy, error) {
+	var chain pan.PolicyChain
+	if len(p.ACL) > 0 {
+		acl, err := pan.NewACL(p.ACL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ACL: %w", err)
+		}
+		chain = append(chain, &acl)
+	}
+	if p.Sequence != "" {
+		seq, err := pan.NewSequence(p.Sequence)
+		if err != nil {
+			return nil, fmt.Errorf("parsing sequence: %w", err)
+		}
+		chain = append(chain, seq)
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// DialSingleStream dials address, i.e., [isd-as,ip]:port, over the
+// single-stream transport on a path allowed by policy. The returned
+// connection owns the underlying QUIC connection and socket.
+func DialSingleStream(ctx context.Context, address string, policy pan.Policy) (net.Conn, error) {
+	remote, err := pan.ResolveUDPAddr(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg := &tls.Config{
+		NextProtos:         []string{quicutil.SingleStreamProto},
+		InsecureSkipVerify: true,
+	}
+	session, err := pan.DialQUIC(ctx, netip.AddrPort{}, remote, address, tlsCfg, nil,
+		pan.WithPolicy(policy))
+	if err != nil {
+		return nil, err
+	}
+	return newSingleStream(session)
+}
+
+// quicSession is the QUIC connection a single stream is opened on.
+type quicSession interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	OpenUniStream() (*quic.SendStream, error)
+	AcceptUniStream(context.Context) (*quic.ReceiveStream, error)
+	CloseWithError(quic.ApplicationErrorCode, string) error
+}
+
+// newSingleStream opens the stream on session, closing the session and its
+// socket if that fails.
+func newSingleStream(session quicSession) (net.Conn, error) {
+	stream, err := quicutil.NewSingleStream(session)
+	if err != nil {
+		session.CloseWithError(quic.ApplicationErrorCode(0), "failed to open stream")
+		return nil, err
+	}
+	return stream, nil
+}
+
+// DialQUICEarly dials address, i.e., [isd-as,ip]:port, over QUIC on a path
+// allowed by policy, e.g., for HTTP/3. The socket is closed together with the
+// returned connection.
+func DialQUICEarly(
+	ctx context.Context,
+	address string,
+	tlsCfg *tls.Config,
+	cfg *quic.Config,
+	policy pan.Policy,
+) (*quic.Conn, error) {
+	remote, err := pan.ResolveUDPAddr(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	session, err := pan.DialQUICEarly(ctx, netip.AddrPort{}, remote, address, tlsCfg, cfg,
+		pan.WithPolicy(policy))
+	if err != nil {
+		return nil, err
+	}
+	// HTTP/3 transports only close the QUIC connection.
+	go func() {
+		<-session.Conn.Context().Done()
+		session.UnderlayConn.Close()
+	}()
+	return session.Conn, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scionupstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+)
+
+// failingSession fails to open streams and records whether it was closed.
+type failingSession struct {
+	closed bool
+}
+
+func (s *failingSession) LocalAddr() net.Addr  { return &net.UDPAddr{} }
+func (s *failingSession) RemoteAddr() net.Addr { return &net.UDPAddr{} }
+
+func (s *failingSession) OpenUniStream() (*quic.SendStream, error) {
+	return nil, errors.New("too many open streams")
+}
+
+func (s *failingSession) AcceptUniStream(context.Context) (*quic.ReceiveStream, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *failingSession) CloseWithError(quic.ApplicationErrorCode, string) error {
+	s.closed = true
+	return nil
+}
+
+func TestNewSingleStreamClosesSession(t *testing.T) {
+	s := &failingSession{}
+	if _, err := newSingleStream(s); err == nil {
+		t.Fatal("opening the stream succeeded")
+	}
+	if !s.closed {
+		t.Error("session not closed")
+	}
+}
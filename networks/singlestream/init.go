@@ -16,9 +16,9 @@ package singlestream
 
 import (
 	"context"
-	"fmt"
 	"net"
-	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/scionproto/scion/pkg/snet"
@@ -27,33 +27,48 @@ import (
 	"github.com/scionproto-contrib/http-proxy/networks"
 	"github.com/scionproto-contrib/http-proxy/networks/singlestream"
 
+	"github.com/scionproto-contrib/caddy-scion/networks/connmetrics"
+	"github.com/scionproto-contrib/caddy-scion/networks/listenaddr"
 	"github.com/scionproto-contrib/caddy-scion/networks/pool"
 )
 
 var (
 	ssNetwork = singlestream.NewNetwork(newUsagePoolWrapper[string, networks.Reusable]())
+
+	// metrics are labelled per listener when the listener is created.
+	metrics atomic.Pointer[connmetrics.PacketConnMetrics]
+	// listenMu serializes the creation of listeners, because the network
+	// takes the metrics of a new listener from a field shared by all of them.
+	listenMu sync.Mutex
 )
 
 func init() {
 	ssNetwork.SetNopLogger()
-	caddy.RegisterNetwork(singlestream.SCIONSingleStream, func(ctx context.Context, network, host, portRange string, portOffset uint, cfg net.ListenConfig) (any, error) {
-		if strings.Contains(portRange, "-") {
-			return nil, fmt.Errorf("port ranges are not supported for SCION listeners, got: %s", portRange)
-		}
-		// PortOffset should be 0 for single ports, we fail if not.
-		if portOffset != 0 {
-			return nil, fmt.Errorf("port offsets are not supported for SCION UDP listeners")
-		}
-		return ssNetwork.Listen(ctx, network, net.JoinHostPort(host, portRange), cfg)
-	}) // used for HTTP1.1/2 over QUIC/UDP/SCION
+	caddy.RegisterNetwork(singlestream.SCIONSingleStream, listen) // used for HTTP1.1/2 over QUIC/UDP/SCION
+}
+
+func listen(ctx context.Context, network, host, portRange string, portOffset uint, cfg net.ListenConfig) (any, error) {
+	address, err := listenaddr.JoinHostPort(host, portRange, portOffset)
+	if err != nil {
+		return nil, err
+	}
+	listenMu.Lock()
+	defer listenMu.Unlock()
+	// Invalid addresses are reported by Listen.
+	if laddr, err := snet.ParseUDPAddr(address); err == nil {
+		ssNetwork.SetPacketConnMetrics(metrics.Load().ForListener(singlestream.SCIONSingleStream, laddr))
+	}
+	return ssNetwork.Listen(ctx, network, address, cfg)
 }
 
 func SetLogger(logger *zap.Logger) {
 	ssNetwork.SetLogger(logger)
 }
 
-func SetPacketConnMetrics(metrics snet.SCIONPacketConnMetrics) {
-	ssNetwork.SetPacketConnMetrics(metrics)
+// SetPacketConnMetrics sets the packet connection metrics of the listeners
+// created afterwards. It is safe to access concurrently.
+func SetPacketConnMetrics(m *connmetrics.PacketConnMetrics) {
+	metrics.Store(m)
 }
 
 type usagePoolWrapper[K comparable, V any] struct {
@@ -15,28 +15,79 @@
 package reverse
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/scionproto-contrib/http-proxy/advertiser"
+	"github.com/scionproto/scion/pkg/snet"
 	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/altsvc"
 )
 
 var (
 	// Interface guards
 	_ caddyhttp.MiddlewareHandler = (*SCIONAdvertiserHandler)(nil)
 	_ caddy.Provisioner           = (*SCIONAdvertiserHandler)(nil)
+	_ caddyfile.Unmarshaler       = (*SCIONAdvertiserHandler)(nil)
 )
 
 func init() {
 	caddy.RegisterModule(SCIONAdvertiserHandler{})
+	httpcaddyfile.RegisterHandlerDirective("advertise_scion", parseCaddyfile)
+	// The header is only added once the response is written, so the handler
+	// can be ordered before the ones producing the response.
+	httpcaddyfile.RegisterDirectiveOrder("advertise_scion", httpcaddyfile.After, "header")
 }
 
+// SCIONAdvertiserHandler adds the Strict-SCION header to responses to
+// requests that were not received over SCION, unless the response already
+// carries one, e.g., copied from the upstream. It wraps the response writer,
+// so it covers the responses of every handler after it, including proxied
+// responses and, if the next handlers fail, the error response.
 type SCIONAdvertiserHandler struct {
+	// The SCION address advertised in the Strict-SCION header, e.g.,
+	// 17-ffaa:1:1103,192.168.56.1:7443. If "auto", the address is derived
+	// from the scion+single-stream and scion listeners of the server,
+	// preferring listeners that serve TLS for requests received over TLS,
+	// and the other ones for plain HTTP requests.
 	StrictScion string `json:"Strict-SCION,omitempty"`
-	logger      *zap.Logger
-	advertiser  *advertiser.Advertiser
+
+	// Overrides the IP of the address derived in auto mode, e.g., the public
+	// IP of a server behind a NAT.
+	PublicIP string `json:"public_ip,omitempty"`
+
+	// Overrides the port of the address derived in auto mode.
+	PublicPort int `json:"public_port,omitempty"`
+
+	// Whether to add an Alt-Svc entry for every HTTP/3 listener of the server
+	// on the scion network, so that clients speaking SCION can switch to
+	// them. PublicIP also applies to these entries. The header is not added
+	// to requests received over HTTP/3 on SCION.
+	AltSvc bool `json:"alt_svc,omitempty"`
+
+	// How long clients may cache the Alt-Svc entries. Default: 24h
+	AltSvcMaxAge caddy.Duration `json:"alt_svc_max_age,omitempty"`
+
+	// The status codes of the responses to advertise SCION on. A single
+	// digit stands for a status class, e.g., 2 for 2xx. Default: all
+	// responses.
+	StatusCodes []int `json:"status_codes,omitempty"`
+
+	logger     *zap.Logger
+	advertiser *advertiser.Advertiser
+	auto       *autoAdvertiser
+	altSvc     *altSvcAdvertiser
 }
 
 // CaddyModule returns the Caddy module information.
@@ -48,16 +99,239 @@ func (SCIONAdvertiserHandler) CaddyModule() caddy.ModuleInfo {
 }
 
 func (s *SCIONAdvertiserHandler) Provision(ctx caddy.Context) error {
-	logger := ctx.Logger()
-	s.advertiser = advertiser.NewAdvertiser(logger, s.StrictScion)
+	s.logger = ctx.Logger()
+	auto := s.StrictScion == StrictSCIONAuto
+	if !auto && s.PublicPort != 0 {
+		return errors.New("public_port requires the auto mode")
+	}
+	if !auto && !s.AltSvc && s.PublicIP != "" {
+		return errors.New("public_ip requires the auto mode or alt_svc")
+	}
+	if !auto {
+		s.advertiser = advertiser.NewAdvertiser(s.logger, s.StrictScion)
+	}
+	if !auto && !s.AltSvc {
+		return nil
+	}
+
+	server, ok := ctx.Value(caddyhttp.ServerCtxKey).(*caddyhttp.Server)
+	if !ok {
+		return errors.New("auto mode and alt_svc are only supported in HTTP servers")
+	}
+	var publicIP netip.Addr
+	if s.PublicIP != "" {
+		ip, err := netip.ParseAddr(s.PublicIP)
+		if err != nil {
+			return fmt.Errorf("parsing public_ip: %w", err)
+		}
+		publicIP = ip.Unmap()
+	}
+	if auto {
+		// The HTTP app is referenced before it is provisioned, so its
+		// settings are available to the handlers.
+		httpPort := caddyhttp.DefaultHTTPPort
+		if app, err := ctx.AppIfConfigured("http"); err == nil {
+			if app, ok := app.(*caddyhttp.App); ok && app.HTTPPort != 0 {
+				httpPort = app.HTTPPort
+			}
+		}
+		s.auto = newAutoAdvertiser(server, s.logger, httpPort, publicIP, s.PublicPort)
+	}
+	if s.AltSvc {
+		if s.AltSvcMaxAge == 0 {
+			s.AltSvcMaxAge = caddy.Duration(altsvc.DefaultMaxAge)
+		}
+		s.altSvc = newAltSvcAdvertiser(server, s.logger, publicIP, time.Duration(s.AltSvcMaxAge))
+	}
 	return nil
 }
 
 // ServeHTTP implements caddyhttp.MiddlewareHandler.
 func (s SCIONAdvertiserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	err := s.advertiser.ServeHTTP(w, r)
-	if err != nil {
+	aw := &advertisingWriter{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+		handler:               s,
+		req:                   r,
+	}
+	err := next.ServeHTTP(aw, r)
+	if err != nil && !aw.written {
+		// The error response is written by the server, with the headers set
+		// on w so far.
+		status := http.StatusInternalServerError
+		var he caddyhttp.HandlerError
+		if errors.As(err, &he) && he.StatusCode != 0 {
+			status = he.StatusCode
+		}
+		s.advertise(w, r, status)
+	}
+	return err
+}
+
+func (s SCIONAdvertiserHandler) advertise(w http.ResponseWriter, r *http.Request, status int) {
+	if !s.matches(status) {
+		return
+	}
+	if s.altSvc != nil {
+		s.altSvc.advertise(w, r)
+	}
+	a := s.advertiser
+	if s.auto != nil {
+		if a = s.auto.get(r); a == nil {
+			return
+		}
+	}
+	if err := a.ServeHTTP(w, r); err != nil {
 		s.logger.Error("Error in SCION advertiser.", zap.Error(err))
 	}
-	return next.ServeHTTP(w, r)
+}
+
+func (s SCIONAdvertiserHandler) matches(status int) bool {
+	if len(s.StatusCodes) == 0 {
+		return true
+	}
+	for _, code := range s.StatusCodes {
+		if caddyhttp.StatusCodeMatches(status, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// advertisingWriter lets the advertiser set its header right before the
+// response header is written, when the headers and the status of the next
+// handlers are known.
+type advertisingWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+	handler SCIONAdvertiserHandler
+	req     *http.Request
+	written bool
+}
+
+func (w *advertisingWriter) WriteHeader(status int) {
+	// Informational responses are followed by the final one.
+	if !w.written && status >= http.StatusOK {
+		w.written = true
+		w.handler.advertise(w.ResponseWriter, w.req, status)
+	}
+	w.ResponseWriterWrapper.WriteHeader(status)
+}
+
+func (w *advertisingWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriterWrapper.Write(b)
+}
+
+// ReadFrom implements io.ReaderFrom. Without it, the ReadFrom of the wrapper
+// would be promoted and write the response without the header.
+func (w *advertisingWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriterWrapper.ReadFrom(r)
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler. Syntax:
+//
+//	advertise_scion [<matcher>] <strict-scion-addr>|auto {
+//		public_ip   <ip>
+//		public_port <port>
+//		alt_svc     [<max-age>]
+//		status      <codes...>
+//	}
+//
+// The address is the SCION address of the site, e.g.,
+// 17-ffaa:1:1103,192.168.56.1:7443, or auto to derive it from the listeners
+// of the server. Status codes are either exact codes or classes, e.g., 2xx.
+func (s *SCIONAdvertiserHandler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	if d.Val() != StrictSCIONAuto {
+		if _, err := snet.ParseUDPAddr(d.Val()); err != nil {
+			return d.Errf("parsing SCION address %q: %v", d.Val(), err)
+		}
+	}
+	s.StrictScion = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "public_ip":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if _, err := netip.ParseAddr(d.Val()); err != nil {
+				return d.Errf("parsing public_ip: %v", err)
+			}
+			s.PublicIP = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		case "public_port":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			port, err := strconv.Atoi(d.Val())
+			if err != nil || port < 1 || port > 65535 {
+				return d.Errf("invalid public_port %q", d.Val())
+			}
+			s.PublicPort = port
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		case "alt_svc":
+			s.AltSvc = true
+			if !d.NextArg() {
+				continue
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing alt_svc max age: %v", err)
+			}
+			s.AltSvcMaxAge = caddy.Duration(dur)
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		case "status":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			for _, arg := range args {
+				code, err := parseStatus(arg)
+				if err != nil {
+					return d.Errf("parsing status %q: %v", arg, err)
+				}
+				s.StatusCodes = append(s.StatusCodes, code)
+			}
+		default:
+			return d.Errf("unrecognized subdirective: %s", d.Val())
+		}
+	}
+	return nil
+}
+
+// parseStatus parses a status code, or a status class such as 2xx.
+func parseStatus(v string) (int, error) {
+	if len(v) == 3 && strings.HasSuffix(v, "xx") {
+		v = v[:1]
+	}
+	code, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if (code < 1 || code > 5) && (code < 100 || code > 599) {
+		return 0, fmt.Errorf("invalid status code %d", code)
+	}
+	return code, nil
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	s := new(SCIONAdvertiserHandler)
+	err := s.UnmarshalCaddyfile(h.Dispenser)
+	return s, err
 }
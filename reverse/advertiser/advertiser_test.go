@@ -0,0 +1,121 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/scionproto-contrib/http-proxy/advertiser"
+	"go.uber.org/zap"
+)
+
+const testStrictSCION = "17-ffaa:1:1103,192.168.56.1:7443"
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "200", want: 200},
+		{in: "599", want: 599},
+		{in: "2xx", want: 2},
+		{in: "5xx", want: 5},
+		{in: "2", want: 2},
+		{in: "0", wantErr: true},
+		{in: "6", wantErr: true},
+		{in: "6xx", wantErr: true},
+		{in: "99", wantErr: true},
+		{in: "600", wantErr: true},
+		{in: "xx", wantErr: true},
+		{in: "2xxx", wantErr: true},
+		{in: "ok", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := parseStatus(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseStatus(%q) = %d, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("parseStatus(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		codes  []int
+		status int
+		want   bool
+	}{
+		{codes: nil, status: 500, want: true},
+		{codes: []int{200}, status: 200, want: true},
+		{codes: []int{200}, status: 204, want: false},
+		{codes: []int{2}, status: 204, want: true},
+		{codes: []int{2}, status: 302, want: false},
+		{codes: []int{404, 5}, status: 404, want: true},
+		{codes: []int{404, 5}, status: 503, want: true},
+		{codes: []int{404, 5}, status: 403, want: false},
+	}
+	for _, tc := range tests {
+		s := SCIONAdvertiserHandler{StatusCodes: tc.codes}
+		if got := s.matches(tc.status); got != tc.want {
+			t.Errorf("codes %v: matches(%d) = %v, want %v", tc.codes, tc.status, got, tc.want)
+		}
+	}
+}
+
+func TestAdvertisingWriter(t *testing.T) {
+	tests := map[string]caddyhttp.HandlerFunc{
+		"write": func(w http.ResponseWriter, _ *http.Request) error {
+			_, err := w.Write([]byte("body"))
+			return err
+		},
+		"read from": func(w http.ResponseWriter, _ *http.Request) error {
+			// Hide the WriteTo of the reader, so that io.Copy uses ReadFrom.
+			_, err := io.Copy(w, struct{ io.Reader }{strings.NewReader("body")})
+			return err
+		},
+		"write header": func(w http.ResponseWriter, _ *http.Request) error {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		},
+		"error": func(http.ResponseWriter, *http.Request) error {
+			return caddyhttp.Error(http.StatusBadGateway, io.EOF)
+		},
+	}
+	for name, next := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := SCIONAdvertiserHandler{
+				logger:     zap.NewNop(),
+				advertiser: advertiser.NewAdvertiser(zap.NewNop(), testStrictSCION),
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			_ = s.ServeHTTP(rec, req, next)
+			if got := rec.Result().Header.Get("Strict-SCION"); got != testStrictSCION {
+				t.Errorf("got Strict-SCION %q, want %q", got, testStrictSCION)
+			}
+		})
+	}
+}
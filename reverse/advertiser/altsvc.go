@@ -0,0 +1,96 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/scionproto/scion/pkg/snet"
+	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/altsvc"
+	"github.com/scionproto-contrib/caddy-scion/reverse/scionrequest"
+)
+
+// altSvcAdvertiser adds the Alt-Svc entries of the HTTP/3 listeners of the
+// server on the scion network. The entries are derived the first time they
+// are needed.
+type altSvcAdvertiser struct {
+	server   *caddyhttp.Server
+	logger   *zap.Logger
+	publicIP netip.Addr
+	maxAge   time.Duration
+
+	// once derives the value on the first request and freezes it for the
+	// lifetime of the handler. Listeners are only bound once, and a config
+	// reload provisions new handlers, which derive their own value.
+	once  sync.Once
+	value string
+}
+
+func newAltSvcAdvertiser(
+	server *caddyhttp.Server,
+	logger *zap.Logger,
+	publicIP netip.Addr,
+	maxAge time.Duration,
+) *altSvcAdvertiser {
+	return &altSvcAdvertiser{
+		server:   server,
+		logger:   logger,
+		publicIP: publicIP,
+		maxAge:   maxAge,
+	}
+}
+
+func (a *altSvcAdvertiser) advertise(w http.ResponseWriter, r *http.Request) {
+	if scionrequest.RemoteAddr(r) != nil && r.ProtoMajor == 3 {
+		// The client already uses HTTP/3 over SCION.
+		return
+	}
+	a.once.Do(a.derive)
+	if a.value != "" {
+		w.Header().Add(altsvc.Header, a.value)
+	}
+}
+
+func (a *altSvcAdvertiser) derive() {
+	var endpoints []*snet.UDPAddr
+	for _, c := range h3Candidates(a.logger, a.server) {
+		if a.publicIP.IsValid() {
+			c.ip = a.publicIP
+		}
+		if !c.ip.IsValid() || c.ip.IsUnspecified() || c.port == 0 {
+			a.logger.Warn("cannot advertise SCION listener without a specific address, set public_ip",
+				zap.Stringer("ia", c.ia), zap.Stringer("ip", c.ip), zap.Int("port", c.port))
+			continue
+		}
+		endpoints = append(endpoints, &snet.UDPAddr{
+			IA:   c.ia,
+			Host: &net.UDPAddr{IP: c.ip.AsSlice(), Port: c.port},
+		})
+	}
+	if len(endpoints) == 0 {
+		a.logger.Warn("no HTTP/3 listener on the scion network to advertise in Alt-Svc",
+			zap.Strings("listen", a.server.Listen))
+		return
+	}
+	a.value = altsvc.Format(endpoints, a.maxAge)
+	a.logger.Info("derived Alt-Svc header from listeners", zap.String("value", a.value))
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/altsvc"
+)
+
+func TestAltSvcSkipsHTTP3OverSCION(t *testing.T) {
+	const value = `h3="[1-ff00:0:110,192.0.2.1]:8443"; ma=86400`
+	a := &altSvcAdvertiser{value: value}
+	// The value is already derived.
+	a.once.Do(func() {})
+
+	scionAddr := &snet.UDPAddr{
+		IA:   addr.MustParseIA("1-ff00:0:111"),
+		Host: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 31000},
+	}
+	ipAddr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 31000}
+	tests := map[string]struct {
+		remote     net.Addr
+		protoMajor int
+		want       bool
+	}{
+		"http3 over scion": {remote: scionAddr, protoMajor: 3},
+		"http3 over ip":    {remote: ipAddr, protoMajor: 3, want: true},
+		"http1 over ip":    {remote: ipAddr, protoMajor: 1, want: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), http3.RemoteAddrContextKey, tc.remote)
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil).WithContext(ctx)
+			r.ProtoMajor = tc.protoMajor
+			w := httptest.NewRecorder()
+			a.advertise(w, r)
+			if got := w.Header().Get(altsvc.Header) == value; got != tc.want {
+				t.Errorf("got Alt-Svc %q, want advertised %v", w.Header().Get(altsvc.Header), tc.want)
+			}
+		})
+	}
+}
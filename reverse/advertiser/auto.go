@@ -0,0 +1,231 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/scionproto-contrib/http-proxy/advertiser"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+	"go.uber.org/zap"
+)
+
+// StrictSCIONAuto derives the advertised address from the listeners of the
+// server.
+const StrictSCIONAuto = "auto"
+
+// autoAdvertiser derives the Strict-SCION value from the SCION listeners of
+// the server the first time it is needed, since the listeners are only bound
+// after the handlers are provisioned. The value is derived separately for
+// requests with and without TLS, so that clients are sent to a listener with
+// the same scheme.
+type autoAdvertiser struct {
+	server     *caddyhttp.Server
+	logger     *zap.Logger
+	httpPort   int
+	publicIP   netip.Addr
+	publicPort int
+
+	mu sync.Mutex
+	// advertisers are the advertisers for requests with and without TLS.
+	advertisers map[bool]*advertiser.Advertiser
+	// warned is set once the listeners that cannot be advertised have
+	// been logged.
+	warned bool
+}
+
+func newAutoAdvertiser(
+	server *caddyhttp.Server,
+	logger *zap.Logger,
+	httpPort int,
+	publicIP netip.Addr,
+	publicPort int,
+) *autoAdvertiser {
+	return &autoAdvertiser{
+		server:      server,
+		logger:      logger,
+		httpPort:    httpPort,
+		publicIP:    publicIP,
+		publicPort:  publicPort,
+		advertisers: make(map[bool]*advertiser.Advertiser),
+	}
+}
+
+// get returns the advertiser for r, or nil if the server has no suitable
+// SCION listener (yet).
+func (a *autoAdvertiser) get(r *http.Request) *advertiser.Advertiser {
+	useTLS := r.TLS != nil
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if adv, ok := a.advertisers[useTLS]; ok {
+		return adv
+	}
+	logger := zap.NewNop()
+	if !a.warned {
+		logger = a.logger
+		a.warned = true
+	}
+	value, ok := a.derive(logger, useTLS)
+	if !ok {
+		logger.Warn("no SCION listener to derive the Strict-SCION header from",
+			zap.Strings("listen", a.server.Listen))
+		return nil
+	}
+	a.logger.Info("derived Strict-SCION header from listeners",
+		zap.String("value", value), zap.Bool("tls", useTLS))
+	a.advertisers[useTLS] = advertiser.NewAdvertiser(a.logger, value)
+	return a.advertisers[useTLS]
+}
+
+type candidate struct {
+	ia   addr.IA
+	ip   netip.Addr
+	port int
+	// singleStream is set for bound single-stream listeners, and unset for
+	// the configured addresses of the HTTP/3 listeners.
+	singleStream bool
+	// tls is set for the listeners serving TLS.
+	tls bool
+}
+
+// derive picks the address to advertise to requests with or without TLS
+// among the SCION listeners of the server. The listeners that cannot be
+// advertised are logged to logger.
+func (a *autoAdvertiser) derive(logger *zap.Logger, useTLS bool) (string, bool) {
+	var candidates []candidate
+	for _, ln := range a.server.Listeners() {
+		if la, ok := ln.Addr().(*snet.UDPAddr); ok {
+			c := fromUDPAddr(la)
+			c.singleStream = true
+			// Same rule as the HTTP app: all listeners but the one on the
+			// HTTP port serve TLS if the server has TLS policies.
+			c.tls = len(a.server.TLSConnPolicies) > 0 && c.port != a.httpPort
+			candidates = append(candidates, c)
+		}
+	}
+	candidates = append(candidates, h3Candidates(logger, a.server)...)
+	return pick(logger, candidates, useTLS, a.publicIP, a.publicPort)
+}
+
+// pick returns the Strict-SCION value of the best candidate for requests with
+// or without TLS, after applying the overrides. Single-stream listeners are
+// preferred over HTTP/3 listeners, since the browser extension reaches sites
+// over the single stream; among those, listeners with the same TLS state as
+// the request, then the listener with the most public IP win, and the first
+// one listed on ties. Candidates without a specific address are logged to
+// logger and skipped.
+func pick(
+	logger *zap.Logger,
+	candidates []candidate,
+	useTLS bool,
+	publicIP netip.Addr,
+	publicPort int,
+) (string, bool) {
+	var (
+		best      candidate
+		bestScore = -1
+	)
+	for _, c := range candidates {
+		if publicIP.IsValid() {
+			c.ip = publicIP
+		}
+		if publicPort != 0 {
+			c.port = publicPort
+		}
+		if !c.ip.IsValid() || c.ip.IsUnspecified() || c.port == 0 {
+			logger.Warn("cannot advertise SCION listener without a specific address, set public_ip",
+				zap.Stringer("ia", c.ia), zap.Stringer("ip", c.ip), zap.Int("port", c.port),
+				zap.Bool("single_stream", c.singleStream))
+			continue
+		}
+		score := publicness(c.ip)
+		if c.tls == useTLS {
+			score += maxPublicness + 1
+		}
+		if c.singleStream {
+			score += 2 * (maxPublicness + 1)
+		}
+		if score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	if bestScore < 0 {
+		return "", false
+	}
+	// Same format as the hand-written values, e.g.,
+	// 17-ffaa:1:1103,192.168.56.1:7443.
+	return fmt.Sprintf("%s,%s", best.ia, netip.AddrPortFrom(best.ip, uint16(best.port))), true
+}
+
+// h3Candidates returns the configured addresses of the HTTP/3 listeners on
+// the scion network. The listeners that cannot be advertised are logged to
+// logger.
+func h3Candidates(logger *zap.Logger, server *caddyhttp.Server) []candidate {
+	var candidates []candidate
+	for _, listen := range server.Listen {
+		na, err := caddy.ParseNetworkAddress(listen)
+		if err != nil || na.Network != "scion" {
+			continue
+		}
+		if na.PortRangeSize() != 1 {
+			logger.Warn("cannot advertise SCION listener on a port range",
+				zap.String("listen", listen))
+			continue
+		}
+		la, err := snet.ParseUDPAddr(na.JoinHostPort(0))
+		if err != nil {
+			logger.Warn("cannot advertise SCION listener",
+				zap.String("listen", listen), zap.Error(err))
+			continue
+		}
+		c := fromUDPAddr(la)
+		c.tls = true
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+func fromUDPAddr(la *snet.UDPAddr) candidate {
+	c := candidate{ia: la.IA}
+	if la.Host != nil {
+		if ip, ok := netip.AddrFromSlice(la.Host.IP); ok {
+			c.ip = ip.Unmap()
+		}
+		c.port = la.Host.Port
+	}
+	return c
+}
+
+// maxPublicness is the rank of global addresses.
+const maxPublicness = 2
+
+// publicness ranks global addresses over private ones, and private ones over
+// loopback addresses.
+func publicness(ip netip.Addr) int {
+	switch {
+	case ip.IsLoopback():
+		return 0
+	case ip.IsPrivate():
+		return 1
+	default:
+		return maxPublicness
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/scionproto/scion/pkg/addr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPublicness(t *testing.T) {
+	tests := map[string]int{
+		"127.0.0.1":    0,
+		"::1":          0,
+		"10.0.0.1":     1,
+		"192.168.56.1": 1,
+		"fd00::1":      1,
+		"203.0.113.1":  2,
+		"2001:db8::1":  2,
+	}
+	for ip, want := range tests {
+		if got := publicness(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("publicness(%s) = %d, want %d", ip, got, want)
+		}
+	}
+}
+
+func TestPick(t *testing.T) {
+	ia := addr.MustParseIA("17-ffaa:1:1103")
+	c := func(ip string, port int, singleStream bool) candidate {
+		return candidate{ia: ia, ip: netip.MustParseAddr(ip), port: port, singleStream: singleStream, tls: true}
+	}
+	plain := func(c candidate) candidate {
+		c.tls = false
+		return c
+	}
+	tests := []struct {
+		name       string
+		candidates []candidate
+		plainHTTP  bool
+		publicIP   string
+		publicPort int
+		want       string
+		wantDrops  int
+	}{
+		{
+			name:       "most public",
+			candidates: []candidate{c("127.0.0.1", 1, true), c("203.0.113.1", 2, true), c("10.0.0.1", 3, true)},
+			want:       "17-ffaa:1:1103,203.0.113.1:2",
+		},
+		{
+			name:       "first on ties",
+			candidates: []candidate{c("10.0.0.1", 1, true), c("10.0.0.2", 2, true)},
+			want:       "17-ffaa:1:1103,10.0.0.1:1",
+		},
+		{
+			name:       "tls listener for tls request",
+			candidates: []candidate{plain(c("10.0.0.1", 7080, true)), c("10.0.0.1", 7443, true)},
+			want:       "17-ffaa:1:1103,10.0.0.1:7443",
+		},
+		{
+			name:       "plain listener for plain request",
+			candidates: []candidate{c("10.0.0.1", 7443, true), plain(c("10.0.0.1", 7080, true))},
+			plainHTTP:  true,
+			want:       "17-ffaa:1:1103,10.0.0.1:7080",
+		},
+		{
+			name:       "same scheme over more public",
+			candidates: []candidate{plain(c("203.0.113.1", 7080, true)), c("10.0.0.1", 7443, true)},
+			want:       "17-ffaa:1:1103,10.0.0.1:7443",
+		},
+		{
+			name:       "single stream of other scheme over h3",
+			candidates: []candidate{c("10.0.0.1", 8443, false), plain(c("10.0.0.1", 7080, true))},
+			want:       "17-ffaa:1:1103,10.0.0.1:7080",
+		},
+		{
+			name:       "single stream over more public h3",
+			candidates: []candidate{c("203.0.113.1", 8443, false), c("127.0.0.1", 7443, true)},
+			want:       "17-ffaa:1:1103,127.0.0.1:7443",
+		},
+		{
+			name:       "h3 without single stream",
+			candidates: []candidate{c("10.0.0.1", 8443, false), c("203.0.113.1", 8443, false)},
+			want:       "17-ffaa:1:1103,203.0.113.1:8443",
+		},
+		{
+			name:       "unspecified skipped",
+			candidates: []candidate{c("0.0.0.0", 7443, true), c("127.0.0.1", 8443, false)},
+			want:       "17-ffaa:1:1103,127.0.0.1:8443",
+			wantDrops:  1,
+		},
+		{
+			name:       "public ip",
+			candidates: []candidate{c("0.0.0.0", 7443, true)},
+			publicIP:   "203.0.113.1",
+			want:       "17-ffaa:1:1103,203.0.113.1:7443",
+		},
+		{
+			name:       "public port",
+			candidates: []candidate{c("10.0.0.1", 7443, true)},
+			publicPort: 443,
+			want:       "17-ffaa:1:1103,10.0.0.1:443",
+		},
+		{
+			name:       "public ip and port",
+			candidates: []candidate{c("::", 7443, true)},
+			publicIP:   "2001:db8::1",
+			publicPort: 443,
+			want:       "17-ffaa:1:1103,[2001:db8::1]:443",
+		},
+		{
+			name:       "unspecified without override",
+			candidates: []candidate{c("0.0.0.0", 7443, true)},
+			wantDrops:  1,
+		},
+		{
+			name:       "no address",
+			candidates: []candidate{{ia: ia, port: 8443, tls: true}},
+			wantDrops:  1,
+		},
+		{
+			name: "no candidates",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var publicIP netip.Addr
+			if tc.publicIP != "" {
+				publicIP = netip.MustParseAddr(tc.publicIP)
+			}
+			core, logs := observer.New(zap.WarnLevel)
+			got, ok := pick(zap.New(core), tc.candidates, !tc.plainHTTP, publicIP, tc.publicPort)
+			if ok != (tc.want != "") || got != tc.want {
+				t.Errorf("got %q, %v, want %q", got, ok, tc.want)
+			}
+			if n := logs.Len(); n != tc.wantDrops {
+				t.Errorf("got %d warnings, want %d", n, tc.wantDrops)
+			}
+		})
+	}
+}
+
+func TestH3Candidates(t *testing.T) {
+	server := &caddyhttp.Server{Listen: []string{
+		"scion/[17-ffaa:1:1103,10.0.0.1]:8443",
+		"scion+single-stream/[17-ffaa:1:1103,10.0.0.1]:7443",
+		"scion/[17-ffaa:1:1103,10.0.0.1]:8443-8444",
+		":443",
+	}}
+	core, logs := observer.New(zap.WarnLevel)
+	got := h3Candidates(zap.New(core), server)
+	want := candidate{
+		ia:   addr.MustParseIA("17-ffaa:1:1103"),
+		ip:   netip.MustParseAddr("10.0.0.1"),
+		port: 8443,
+		tls:  true,
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("got %+v, want [%+v]", got, want)
+	}
+	// The port range is logged.
+	if n := logs.FilterField(zap.String("listen", server.Listen[2])).Len(); n != 1 || logs.Len() != 1 {
+		t.Errorf("got %d warnings, %d for the port range, want 1 for the port range", logs.Len(), n)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package appconfig contains the configuration shared by the different
+// flavours of the `scion` app. It does not register any module itself, so it
+// can be imported by all of them without clashing on the app ID. It does
+// register the `scion` global option, which configures whichever flavour of
+// the app is built in.
+package appconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/private/path/pathpol"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/native"
+)
+
+func init() {
+	httpcaddyfile.RegisterGlobalOption("scion", parseGlobalOption)
+}
+
+// Config is embedded in every `scion` app, so that the JSON and Caddyfile
+// representation is the same regardless of which networks a binary is built
+// with.
+type Config struct {
+	// Path to the SCION environment file used by `scion` listeners. If
+	// empty, SCION_ENV_FILE is consulted, falling back to
+	// /etc/scion/environment.json.
+	EnvironmentFile string `json:"environment_file,omitempty"`
+
+	// Addresses of the SCION daemons keyed by ISD-AS, used by `scion`
+	// listeners. Entries in this map take precedence over the ones in the
+	// environment file.
+	Daemons map[addr.IA]string `json:"daemons,omitempty"`
+
+	// Whether `scion` listeners whose daemon is not reachable yet are
+	// returned immediately and bound in the background, instead of failing
+	// the config load. Readiness can be polled at the /scion/ready admin
+	// endpoint. Listeners are kept across config reloads, so changing this
+	// setting only affects listeners on new addresses until Caddy is
+	// restarted.
+	Lazy bool `json:"lazy,omitempty"`
+
+	// Path that `scion` listeners send replies on: "reverse" uses the
+	// reversed incoming path, "latency" the path with the lowest latency
+	// known to the daemon and "policy" the first path allowed by
+	// ReplyPathPolicy. Selected paths are cached per remote ISD-AS until they
+	// expire. Default: reverse.
+	ReplyPath string `json:"reply_path,omitempty"`
+
+	// Path policy used by the "policy" reply path mode.
+	ReplyPathPolicy *pathpol.Policy `json:"reply_path_policy,omitempty"`
+
+	// Limits of the `scion` listeners on the traffic of remote sources.
+	Limits native.Limits `json:"limits,omitzero"`
+
+	// Minimum level of the logs emitted by the SCION networks. The level can
+	// only be raised above the one of the Caddy logger, not lowered.
+	// Default: the level of the Caddy logger.
+	LogLevel string `json:"log_level,omitempty"`
+}
+
+// UnmarshalCaddyfile sets up the configuration from Caddyfile tokens. Syntax:
+//
+//	scion {
+//		environment_file <path>
+//		daemon <isd-as> <address>
+//		lazy
+//		reply_path reverse|latency
+//		reply_path policy {
+//			acl      +|- [<hop predicate>]
+//			sequence <sequence>
+//		}
+//		limits {
+//			packet_rate     <rate> [<burst>]
+//			handshake_rate  <rate> [<burst>]
+//			per_host
+//			max_connections <n>
+//		}
+//		log_level <level>
+//	}
+//
+// The daemon subdirective can be repeated, once per ISD-AS. The acl
+// subdirective of the reply path policy is repeated for every ACL entry, in
+// order.
+func (c *Config) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume option name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "environment_file":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			c.EnvironmentFile = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		case "daemon":
+			var ia, address string
+			if !d.Args(&ia, &address) {
+				return d.ArgErr()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			parsed, err := addr.ParseIA(ia)
+			if err != nil {
+				return d.Errf("parsing ISD-AS %q: %v", ia, err)
+			}
+			if c.Daemons == nil {
+				c.Daemons = make(map[addr.IA]string)
+			}
+			if _, ok := c.Daemons[parsed]; ok {
+				return d.Errf("duplicate daemon for ISD-AS %s", parsed)
+			}
+			c.Daemons[parsed] = address
+		case "lazy":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			c.Lazy = true
+		case "reply_path":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			c.ReplyPath = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			if c.ReplyPath != "policy" {
+				continue
+			}
+			policy, err := unmarshalPolicy(d)
+			if err != nil {
+				return err
+			}
+			c.ReplyPathPolicy = policy
+		case "limits":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			if err := unmarshalLimits(d, &c.Limits); err != nil {
+				return err
+			}
+		case "log_level":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if _, err := zapcore.ParseLevel(d.Val()); err != nil {
+				return d.Errf("parsing log level: %v", err)
+			}
+			c.LogLevel = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unrecognized subdirective: %s", d.Val())
+		}
+	}
+	return nil
+}
+
+// parseGlobalOption configures the `scion` app from the global option block
+// of the same name. Every flavour of the app embeds Config, so the JSON of
+// Config is the one of the app.
+func parseGlobalOption(d *caddyfile.Dispenser, _ any) (any, error) {
+	c := new(Config)
+	if err := c.UnmarshalCaddyfile(d); err != nil {
+		return nil, err
+	}
+	return httpcaddyfile.App{
+		Name:  "scion",
+		Value: caddyconfig.JSON(c, nil),
+	}, nil
+}
+
+func unmarshalLimits(d *caddyfile.Dispenser, l *native.Limits) error {
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "packet_rate":
+			if err := unmarshalRate(d, &l.PacketRate, &l.PacketBurst); err != nil {
+				return err
+			}
+		case "handshake_rate":
+			if err := unmarshalRate(d, &l.HandshakeRate, &l.HandshakeBurst); err != nil {
+				return err
+			}
+		case "per_host":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			l.PerHost = true
+		case "max_connections":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil || n < 0 {
+				return d.Errf("invalid max_connections %q", d.Val())
+			}
+			l.MaxConnections = n
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unrecognized subdirective: %s", d.Val())
+		}
+	}
+	return nil
+}
+
+func unmarshalRate(d *caddyfile.Dispenser, r *float64, burst *int) error {
+	args := d.RemainingArgs()
+	if len(args) == 0 || len(args) > 2 {
+		return d.ArgErr()
+	}
+	v, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || v < 0 {
+		return d.Errf("invalid rate %q", args[0])
+	}
+	*r = v
+	if len(args) == 2 {
+		b, err := strconv.Atoi(args[1])
+		if err != nil || b < 0 {
+			return d.Errf("invalid burst %q", args[1])
+		}
+		*burst = b
+	}
+	return nil
+}
+
+func unmarshalPolicy(d *caddyfile.Dispenser) (*pathpol.Policy, error) {
+	var (
+		entries  []*pathpol.ACLEntry
+		sequence *pathpol.Sequence
+	)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "acl":
+			args := d.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return nil, d.ArgErr()
+			}
+			var entry pathpol.ACLEntry
+			if err := entry.LoadFromString(strings.Join(args, " ")); err != nil {
+				return nil, d.Errf("parsing ACL entry: %v", err)
+			}
+			entries = append(entries, &entry)
+		case "sequence":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			seq, err := pathpol.NewSequence(d.Val())
+			if err != nil {
+				return nil, d.Errf("parsing sequence: %v", err)
+			}
+			sequence = seq
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+		default:
+			return nil, d.Errf("unrecognized subdirective: %s", d.Val())
+		}
+	}
+	var acl *pathpol.ACL
+	if len(entries) > 0 {
+		var err error
+		if acl, err = pathpol.NewACL(entries...); err != nil {
+			return nil, d.Errf("invalid ACL: %v", err)
+		}
+	}
+	if acl == nil && sequence == nil {
+		return nil, d.Err("missing reply path policy")
+	}
+	return pathpol.NewPolicy("reply_path", acl, sequence, nil), nil
+}
+
+// NativeOptions returns the JSON names of the options set in c that only
+// apply to `scion` listeners, so that flavours of the app built without them
+// can reject these options instead of ignoring them.
+func (c *Config) NativeOptions() []string {
+	var opts []string
+	if c.EnvironmentFile != "" {
+		opts = append(opts, "environment_file")
+	}
+	if len(c.Daemons) > 0 {
+		opts = append(opts, "daemons")
+	}
+	if c.Lazy {
+		opts = append(opts, "lazy")
+	}
+	if c.ReplyPath != "" {
+		opts = append(opts, "reply_path")
+	}
+	if c.ReplyPathPolicy != nil {
+		opts = append(opts, "reply_path_policy")
+	}
+	if c.Limits != (native.Limits{}) {
+		opts = append(opts, "limits")
+	}
+	return opts
+}
+
+// Logger returns the logger to be used by the SCION networks, honoring the
+// configured log level.
+func (c *Config) Logger(ctx caddy.Context) (*zap.Logger, error) {
+	logger := ctx.Logger()
+	if c.LogLevel == "" {
+		return logger, nil
+	}
+	lvl, err := zapcore.ParseLevel(c.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("parsing log level: %w", err)
+	}
+	if !logger.Core().Enabled(lvl) {
+		// zap.IncreaseLevel cannot lower the level of the core.
+		return logger, nil
+	}
+	return logger.WithOptions(zap.IncreaseLevel(lvl)), nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+func TestAdaptGlobalOption(t *testing.T) {
+	tests := []struct {
+		name      string
+		caddyfile string
+		want      string
+	}{
+		{
+			name: "empty",
+			caddyfile: "{\n" +
+				"\tscion\n" +
+				"}\n",
+			want: `{}`,
+		},
+		{
+			name: "all",
+			caddyfile: "{\n" +
+				"\tscion {\n" +
+				"\t\tenvironment_file /etc/scion/env.json\n" +
+				"\t\tdaemon 1-ff00:0:110 127.0.0.1:30255\n" +
+				"\t\tdaemon 1-ff00:0:111 127.0.0.2:30255\n" +
+				"\t\tlazy\n" +
+				"\t\treply_path policy {\n" +
+				"\t\t\tacl - 1-ff00:0:112\n" +
+				"\t\t\tacl +\n" +
+				"\t\t\tsequence \"1-ff00:0:110 0*\"\n" +
+				"\t\t}\n" +
+				"\t\tlimits {\n" +
+				"\t\t\tpacket_rate 100 200\n" +
+				"\t\t\thandshake_rate 5\n" +
+				"\t\t\tper_host\n" +
+				"\t\t\tmax_connections 10\n" +
+				"\t\t}\n" +
+				"\t\tlog_level warn\n" +
+				"\t}\n" +
+				"}\n",
+			want: `{
+				"environment_file": "/etc/scion/env.json",
+				"daemons": {
+					"1-ff00:0:110": "127.0.0.1:30255",
+					"1-ff00:0:111": "127.0.0.2:30255"
+				},
+				"lazy": true,
+				"reply_path": "policy",
+				"reply_path_policy": {
+					"acl": ["- 1-ff00:0:112#0", "+"],
+					"sequence": "1-ff00:0:110 0*"
+				},
+				"limits": {
+					"packet_rate": 100,
+					"packet_burst": 200,
+					"handshake_rate": 5,
+					"per_host": true,
+					"max_connections": 10
+				},
+				"log_level": "warn"
+			}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := adapt(t, tc.caddyfile)
+			assertJSONEqual(t, got, []byte(tc.want))
+
+			// The adapted JSON has to load into the app config and marshal
+			// back to the same JSON.
+			var c Config
+			if err := json.Unmarshal(got, &c); err != nil {
+				t.Fatalf("unmarshalling adapted config: %v", err)
+			}
+			again, err := json.Marshal(c)
+			if err != nil {
+				t.Fatalf("marshalling config: %v", err)
+			}
+			assertJSONEqual(t, again, got)
+		})
+	}
+}
+
+func TestAdaptGlobalOptionErrors(t *testing.T) {
+	tests := map[string]string{
+		"argument":         `scion foo`,
+		"unknown":          `scion { foo }`,
+		"invalid ISD-AS":   `scion { daemon 1-ff00 127.0.0.1:30255 }`,
+		"duplicate daemon": "scion {\ndaemon 1-ff00:0:110 a:1\ndaemon 1-ff00:0:110 b:1\n}",
+		"missing policy":   `scion { reply_path policy }`,
+		"invalid rate":     `scion { limits { packet_rate fast } }`,
+		"invalid level":    `scion { log_level loud }`,
+	}
+	for name, option := range tests {
+		t.Run(name, func(t *testing.T) {
+			adapter := caddyfile.Adapter{ServerType: httpcaddyfile.ServerType{}}
+			if _, _, err := adapter.Adapt([]byte("{\n"+option+"\n}"), nil); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestNativeOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want []string
+	}{
+		{name: "empty", json: `{}`},
+		{name: "log level only", json: `{"log_level": "warn"}`},
+		{
+			name: "all",
+			json: `{
+				"environment_file": "/etc/scion/env.json",
+				"daemons": {"1-ff00:0:110": "127.0.0.1:30255"},
+				"lazy": true,
+				"reply_path": "policy",
+				"reply_path_policy": {"sequence": "0*"},
+				"limits": {"max_connections": 10},
+				"log_level": "warn"
+			}`,
+			want: []string{"environment_file", "daemons", "lazy", "reply_path", "reply_path_policy", "limits"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var c Config
+			if err := json.Unmarshal([]byte(tc.json), &c); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.NativeOptions(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// adapt adapts the Caddyfile to JSON and returns the config of the `scion`
+// app.
+func adapt(t *testing.T, input string) []byte {
+	t.Helper()
+	adapter := caddyfile.Adapter{ServerType: httpcaddyfile.ServerType{}}
+	out, warnings, err := adapter.Adapt([]byte(input), nil)
+	if err != nil {
+		t.Fatalf("adapting Caddyfile: %v", err)
+	}
+	if len(warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	var cfg struct {
+		Apps map[string]json.RawMessage `json:"apps"`
+	}
+	if err := json.Unmarshal(out, &cfg); err != nil {
+		t.Fatalf("unmarshalling adapted config: %v", err)
+	}
+	app, ok := cfg.Apps["scion"]
+	if !ok {
+		t.Fatalf("no scion app in %s", out)
+	}
+	return app
+}
+
+func assertJSONEqual(t *testing.T, got, want []byte) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("unmarshalling %s: %v", got, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatalf("unmarshalling %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
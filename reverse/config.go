@@ -16,33 +16,42 @@ package reverse
 
 import (
 	"github.com/caddyserver/caddy/v2"
-	snetmetrics "github.com/scionproto/scion/pkg/snet/metrics"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 
+	"github.com/scionproto-contrib/caddy-scion/networks/connmetrics"
 	"github.com/scionproto-contrib/caddy-scion/networks/native"
 	"github.com/scionproto-contrib/caddy-scion/networks/singlestream"
+	"github.com/scionproto-contrib/caddy-scion/reverse/appconfig"
 )
 
 var (
 	// Interface guards
-	_ caddy.Module      = (*SCION)(nil)
-	_ caddy.Provisioner = (*SCION)(nil)
-	_ caddy.App         = (*SCION)(nil)
+	_ caddy.Module          = (*SCION)(nil)
+	_ caddy.Provisioner     = (*SCION)(nil)
+	_ caddy.App             = (*SCION)(nil)
+	_ caddy.CleanerUpper    = (*SCION)(nil)
+	_ caddyfile.Unmarshaler = (*SCION)(nil)
 )
 
 var (
-	metrics = snetmetrics.NewSCIONPacketConnMetrics()
+	metrics      = connmetrics.NewPacketConnMetrics()
+	scmpMetrics  = native.NewSCMPMetrics()
+	guardMetrics = native.NewGuardMetrics()
 )
 
 func init() {
 	caddy.RegisterModule(SCION{})
 }
 
-// SCION implements a caddy module. Currently, it is used to initialize the
-// logger for the global network. In the future, additional configuration can be
-// parsed with this component.
+// SCION implements a caddy module. It is used to initialize the logger and
+// the configuration of the global networks.
 //
 // Has to be configured as Caddy app to be executed.
-type SCION struct{}
+type SCION struct {
+	appconfig.Config
+
+	restoreConfig func()
+}
 
 func (SCION) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -54,10 +63,32 @@ func (SCION) CaddyModule() caddy.ModuleInfo {
 }
 
 func (s *SCION) Provision(ctx caddy.Context) error {
-	native.SetLogger(ctx.Logger())
-	singlestream.SetLogger(ctx.Logger())
+	logger, err := s.Config.Logger(ctx)
+	if err != nil {
+		return err
+	}
+	collectors := append(metrics.Collectors(), scmpMetrics.Collectors()...)
+	collectors = append(collectors, guardMetrics.Collectors()...)
+	if err := connmetrics.Register(ctx.GetMetricsRegistry(), collectors...); err != nil {
+		return err
+	}
+	native.SetLogger(logger)
+	singlestream.SetLogger(logger)
 
+	replyPath, err := native.NewReplyPathSelector(s.ReplyPath, s.ReplyPathPolicy)
+	if err != nil {
+		return err
+	}
+	s.restoreConfig = native.SetConfig(native.Config{
+		EnvironmentFile: s.EnvironmentFile,
+		Daemons:         s.Daemons,
+		Lazy:            s.Lazy,
+		ReplyPath:       replyPath,
+		Limits:          s.Limits,
+	})
 	native.SetPacketConnMetrics(metrics)
+	native.SetSCMPMetrics(scmpMetrics)
+	native.SetGuardMetrics(guardMetrics)
 	singlestream.SetPacketConnMetrics(metrics)
 	return nil
 }
@@ -71,3 +102,17 @@ func (s *SCION) Stop() error {
 	// no-op
 	return nil
 }
+
+// Cleanup restores the network configuration of the running config if this
+// one fails to load. It does nothing once another config has been provisioned.
+func (s *SCION) Cleanup() error {
+	if s.restoreConfig != nil {
+		s.restoreConfig()
+	}
+	return nil
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (s *SCION) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	return s.Config.UnmarshalCaddyfile(d)
+}
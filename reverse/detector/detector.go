@@ -18,6 +18,8 @@ import (
 	"net/http"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/scionproto-contrib/http-proxy/detector"
 	"go.uber.org/zap"
@@ -27,12 +29,20 @@ var (
 	// Interface guards
 	_ caddyhttp.MiddlewareHandler = (*SCIONDetectorHandler)(nil)
 	_ caddy.Provisioner           = (*SCIONDetectorHandler)(nil)
+	_ caddyfile.Unmarshaler       = (*SCIONDetectorHandler)(nil)
 )
 
 func init() {
 	caddy.RegisterModule(SCIONDetectorHandler{})
+	httpcaddyfile.RegisterHandlerDirective("detect_scion", parseCaddyfile)
+	// The headers are set on the request before any other handler, such as
+	// reverse_proxy, gets to see it.
+	httpcaddyfile.RegisterDirectiveOrder("detect_scion", httpcaddyfile.Before, "header")
 }
 
+// SCIONDetectorHandler sets the X-SCION request header to "on" for requests
+// received over SCION, together with X-SCION-Remote-Addr, and to "off"
+// otherwise.
 type SCIONDetectorHandler struct {
 	logger   *zap.Logger
 	detector *detector.Detector
@@ -60,3 +70,24 @@ func (s *SCIONDetectorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request,
 	}
 	return next.ServeHTTP(w, r)
 }
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler. The directive takes
+// no arguments:
+//
+//	detect_scion [<matcher>]
+func (SCIONDetectorHandler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	if d.NextBlock(0) {
+		return d.Err("detect_scion does not take a block")
+	}
+	return nil
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	s := new(SCIONDetectorHandler)
+	err := s.UnmarshalCaddyfile(h.Dispenser)
+	return s, err
+}
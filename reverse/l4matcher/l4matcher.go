@@ -0,0 +1,63 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/scionproto/scion/pkg/snet"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/scionmatch"
+)
+
+var (
+	// Interface guards
+	_ layer4.ConnMatcher = (*MatchSCION)(nil)
+	_ caddy.Provisioner  = (*MatchSCION)(nil)
+)
+
+func init() {
+	caddy.RegisterModule(MatchSCION{})
+}
+
+// MatchSCION matches connections accepted on scion+single-stream or
+// scion+udp listeners by the ISD-AS and host of the peer. It does not read
+// from the connection, so it can be used before any TLS handshake. See
+// scionmatch.Filter for the syntax of the entries; an empty matcher matches
+// every SCION connection.
+type MatchSCION struct {
+	scionmatch.Filter
+}
+
+// CaddyModule returns the Caddy module information.
+func (MatchSCION) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "layer4.matchers.scion",
+		New: func() caddy.Module { return new(MatchSCION) },
+	}
+}
+
+func (m *MatchSCION) Provision(caddy.Context) error {
+	return m.Filter.Provision()
+}
+
+// Match implements layer4.ConnMatcher.
+func (m MatchSCION) Match(cx *layer4.Connection) (bool, error) {
+	addr, ok := cx.Conn.RemoteAddr().(*snet.UDPAddr)
+	if !ok {
+		return false, nil
+	}
+	return m.Filter.Match(addr), nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/scionmatch"
+)
+
+// remoteConn is a connection from remote.
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c remoteConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443}
+}
+
+func TestMatch(t *testing.T) {
+	scionAddr := &snet.UDPAddr{
+		IA:   addr.MustParseIA("1-ff00:0:110"),
+		Host: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 31000},
+	}
+	udpAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 31000}
+
+	tests := map[string]struct {
+		filter scionmatch.Filter
+		remote net.Addr
+		want   bool
+	}{
+		"any scion": {
+			remote: scionAddr,
+			want:   true,
+		},
+		"matching isd-as and host": {
+			filter: scionmatch.Filter{ISDs: []string{"1"}, ASes: []string{"ff00:0:110"}, Hosts: []string{"10.0.0.0/8"}},
+			remote: scionAddr,
+			want:   true,
+		},
+		"excluded as": {
+			filter: scionmatch.Filter{ASes: []string{"!ff00:0:110"}},
+			remote: scionAddr,
+		},
+		"udp": {
+			remote: udpAddr,
+		},
+		"udp with exclusion": {
+			filter: scionmatch.Filter{ISDs: []string{"!2"}},
+			remote: udpAddr,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := MatchSCION{Filter: tc.filter}
+			if err := m.Provision(caddy.Context{}); err != nil {
+				t.Fatal(err)
+			}
+			cx := layer4.WrapConnection(remoteConn{remote: tc.remote}, nil, zap.NewNop())
+			got, err := m.Match(cx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/scionupstream"
+)
+
+var (
+	// Interface guards
+	_ layer4.NextHandler = (*SCIONProxy)(nil)
+	_ caddy.Provisioner  = (*SCIONProxy)(nil)
+	_ caddy.Validator    = (*SCIONProxy)(nil)
+)
+
+const defaultDialTimeout = 5 * time.Second
+
+func init() {
+	caddy.RegisterModule(new(SCIONProxy))
+}
+
+// SCIONProxy is a layer4 handler that proxies the connection to an upstream
+// reachable over SCION, using the single-stream transport. It allows IP
+// clients to be tunnelled into SCION-only services.
+//
+// It is separate from the layer4 proxy handler because that handler dials its
+// upstreams with net.Dial, which only knows the networks of the standard
+// library, and offers no way to plug in another dialer. Registering a `scion`
+// network with Caddy only affects listeners, not net.Dial. The upstreams are
+// configured like the ones of the proxy handler, so moving a route to SCION
+// upstreams only changes the handler name and the dial addresses.
+type SCIONProxy struct {
+	// Upstreams to proxy to. Each upstream has exactly one dial address of
+	// the form [isd-as,ip]:port, which may contain placeholders. Upstreams
+	// are tried in a round-robin fashion; if dialing one fails, the next one
+	// is tried.
+	Upstreams []*Upstream `json:"upstreams,omitempty"`
+
+	// Policy restricting the paths used to reach the upstreams.
+	scionupstream.PathPolicy
+
+	// How long to wait for the connection to an upstream to be
+	// established.
+	// Default: 5s
+	DialTimeout caddy.Duration `json:"dial_timeout,omitempty"`
+
+	policy pan.Policy
+	next   atomic.Uint64
+	logger *zap.Logger
+}
+
+// Upstream is an upstream of the SCION proxy.
+type Upstream struct {
+	// The SCION address to dial.
+	Dial []string `json:"dial,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*SCIONProxy) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "layer4.handlers.scion_proxy",
+		New: func() caddy.Module { return new(SCIONProxy) },
+	}
+}
+
+func (h *SCIONProxy) Provision(ctx caddy.Context) error {
+	h.logger = ctx.Logger()
+	if h.DialTimeout == 0 {
+		h.DialTimeout = caddy.Duration(defaultDialTimeout)
+	}
+	policy, err := h.PathPolicy.Build()
+	if err != nil {
+		return err
+	}
+	h.policy = policy
+	return nil
+}
+
+func (h *SCIONProxy) Validate() error {
+	if len(h.Upstreams) == 0 {
+		return errors.New("no upstreams")
+	}
+	for i, u := range h.Upstreams {
+		if len(u.Dial) != 1 {
+			return fmt.Errorf("upstream %d: exactly one dial address required, got %d",
+				i, len(u.Dial))
+		}
+	}
+	return nil
+}
+
+// Handle implements layer4.NextHandler. The proxy is terminal; next is never
+// called.
+func (h *SCIONProxy) Handle(down *layer4.Connection, _ layer4.Handler) error {
+	repl := down.Context.Value(layer4.ReplacerCtxKey).(*caddy.Replacer)
+
+	up, err := h.dial(down, repl)
+	if err != nil {
+		return err
+	}
+	defer up.Close()
+
+	h.proxy(down, up)
+	return nil
+}
+
+// dial connects to the first upstream that can be reached, starting at the
+// next upstream in the rotation.
+func (h *SCIONProxy) dial(down *layer4.Connection, repl *caddy.Replacer) (net.Conn, error) {
+	start := h.next.Add(1) - 1
+	var errs []error
+	for i := range h.Upstreams {
+		u := h.Upstreams[(start+uint64(i))%uint64(len(h.Upstreams))]
+		address := repl.ReplaceAll(u.Dial[0], "")
+
+		ctx, cancel := context.WithTimeout(down.Context, time.Duration(h.DialTimeout))
+		up, err := scionupstream.DialSingleStream(ctx, address, h.policy)
+		cancel()
+		h.logger.Debug("dial upstream",
+			zap.String("remote", down.RemoteAddr().String()),
+			zap.String("upstream", address),
+			zap.Error(err))
+		if err == nil {
+			return up, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", address, err))
+	}
+	return nil, fmt.Errorf("dialing upstreams: %w", errors.Join(errs...))
+}
+
+// proxy copies data in both directions until both sides are done. Each side
+// is half-closed once the other one reaches EOF, or closed if it cannot be
+// half-closed. The downstream connection is closed outright if the upstream
+// connection fails.
+func (h *SCIONProxy) proxy(down *layer4.Connection, up net.Conn) {
+	var downClosed atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := io.Copy(down, up)
+		if err != nil && !downClosed.Load() {
+			h.logger.Error("upstream connection",
+				zap.String("local_address", up.LocalAddr().String()),
+				zap.String("remote_address", up.RemoteAddr().String()),
+				zap.Error(err))
+		}
+		if c, ok := down.Conn.(closeWriter); ok && err == nil {
+			_ = c.CloseWrite()
+		} else {
+			_ = down.Close()
+		}
+	}()
+
+	_, _ = io.Copy(up, down)
+	downClosed.Store(true)
+	if c, ok := up.(closeWriter); ok {
+		_ = c.CloseWrite()
+	} else {
+		up.Close()
+	}
+	<-done
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mholt/caddy-l4/layer4"
+	"go.uber.org/zap"
+)
+
+// stubUpstream sends its response, then fails with err, and records what it
+// receives.
+type stubUpstream struct {
+	net.Conn
+	response io.Reader
+	err      error
+
+	mu          sync.Mutex
+	received    bytes.Buffer
+	closedWrite bool
+}
+
+func (u *stubUpstream) Read(b []byte) (int, error) {
+	n, err := u.response.Read(b)
+	if err == io.EOF {
+		err = u.err
+	}
+	return n, err
+}
+
+func (u *stubUpstream) Write(b []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.received.Write(b)
+}
+
+func (u *stubUpstream) CloseWrite() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closedWrite = true
+	return nil
+}
+
+func (u *stubUpstream) LocalAddr() net.Addr  { return &net.UDPAddr{} }
+func (u *stubUpstream) RemoteAddr() net.Addr { return &net.UDPAddr{} }
+
+// tcpPair returns the two ends of a TCP connection, which can be half-closed.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// pipePair returns the two ends of a connection that cannot be half-closed.
+func pipePair(t *testing.T) (net.Conn, net.Conn) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestProxy(t *testing.T) {
+	tests := map[string]struct {
+		pair func(*testing.T) (net.Conn, net.Conn)
+		err  error
+		// halfClosed reports whether the downstream connection is expected
+		// to stay open for the request after the response.
+		halfClosed bool
+	}{
+		"half-close": {
+			pair:       tcpPair,
+			err:        io.EOF,
+			halfClosed: true,
+		},
+		"upstream error": {
+			pair: tcpPair,
+			err:  errors.New("connection reset"),
+		},
+		"no half-close downstream": {
+			pair: pipePair,
+			err:  io.EOF,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, server := tc.pair(t)
+			if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			up := &stubUpstream{response: strings.NewReader("response"), err: tc.err}
+			h := &SCIONProxy{logger: zap.NewNop()}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				h.proxy(layer4.WrapConnection(server, nil, zap.NewNop()), up)
+			}()
+
+			// The response is received before the downstream connection is
+			// closed, either half or fully.
+			got, err := io.ReadAll(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "response" {
+				t.Errorf("got response %q, want %q", got, "response")
+			}
+
+			if !tc.halfClosed {
+				// The proxy returns while the client still has its side open.
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("proxy did not return")
+				}
+				return
+			}
+
+			select {
+			case <-done:
+				t.Fatal("proxy returned before the client was done")
+			case <-time.After(50 * time.Millisecond):
+			}
+			if _, err := io.WriteString(client, "request"); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.(*net.TCPConn).CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("proxy did not return")
+			}
+			up.mu.Lock()
+			defer up.mu.Unlock()
+			if got := up.received.String(); got != "request" {
+				t.Errorf("got request %q, want %q", got, "request")
+			}
+			if !up.closedWrite {
+				t.Error("upstream not half-closed")
+			}
+		})
+	}
+}
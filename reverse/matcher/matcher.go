@@ -0,0 +1,150 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/scionmatch"
+	"github.com/scionproto-contrib/caddy-scion/reverse/scionrequest"
+)
+
+var (
+	// Interface guards
+	_ caddyhttp.RequestMatcherWithError = (*MatchSCION)(nil)
+	_ caddy.Provisioner                 = (*MatchSCION)(nil)
+	_ caddy.Validator                   = (*MatchSCION)(nil)
+	_ caddyfile.Unmarshaler             = (*MatchSCION)(nil)
+)
+
+const (
+	TransportSCION = "scion"
+	TransportIP    = "ip"
+)
+
+func init() {
+	caddy.RegisterModule(MatchSCION{})
+}
+
+// MatchSCION matches requests by their transport and, for requests received
+// over SCION, by the ISD-AS and host of the peer. See scionmatch.Filter for
+// the syntax of the ISD, AS and host entries.
+type MatchSCION struct {
+	// Transport is either "scion" (default) or "ip". Requests received over
+	// SCION only match "scion".
+	Transport string `json:"transport,omitempty"`
+	scionmatch.Filter
+}
+
+// CaddyModule returns the Caddy module information.
+func (MatchSCION) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.matchers.scion",
+		New: func() caddy.Module { return new(MatchSCION) },
+	}
+}
+
+func (m *MatchSCION) Provision(caddy.Context) error {
+	if m.Transport == "" {
+		m.Transport = TransportSCION
+	}
+	return m.Filter.Provision()
+}
+
+func (m *MatchSCION) Validate() error {
+	switch m.Transport {
+	case TransportSCION:
+	case TransportIP:
+		if !m.Filter.Empty() {
+			return fmt.Errorf("transport %q cannot be combined with ISDs, ASes or hosts", m.Transport)
+		}
+	default:
+		return fmt.Errorf("unknown transport %q", m.Transport)
+	}
+	return nil
+}
+
+// Match implements caddyhttp.RequestMatcher.
+func (m MatchSCION) Match(r *http.Request) bool {
+	match, _ := m.MatchWithError(r)
+	return match
+}
+
+// MatchWithError implements caddyhttp.RequestMatcherWithError.
+func (m MatchSCION) MatchWithError(r *http.Request) (bool, error) {
+	addr := scionrequest.RemoteAddr(r)
+	if m.Transport == TransportIP {
+		return addr == nil, nil
+	}
+	return addr != nil && m.Filter.Match(addr), nil
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler. Syntax:
+//
+//	scion [scion|ip] {
+//		transport scion|ip
+//		isd       <isd|isd-isd|!isd...>
+//		as        <as|as-as|!as...>
+//		host      <prefix|ip|!prefix...>
+//	}
+func (m *MatchSCION) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	// iterate to merge multiple matchers into one
+	for d.Next() {
+		if d.NextArg() {
+			m.Transport = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "transport":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Transport = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			case "isd":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				m.ISDs = append(m.ISDs, args...)
+			case "as":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				m.ASes = append(m.ASes, args...)
+			case "host":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				m.Hosts = append(m.Hosts, args...)
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+	return nil
+}
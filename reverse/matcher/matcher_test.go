@@ -0,0 +1,166 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/scionmatch"
+)
+
+// streamConn is the connection of an HTTP/1.1 or HTTP/2 request.
+type streamConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c streamConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestMatchWithError(t *testing.T) {
+	scionAddr := func(ia, ip string) *snet.UDPAddr {
+		return &snet.UDPAddr{
+			IA:   addr.MustParseIA(ia),
+			Host: &net.UDPAddr{IP: net.ParseIP(ip), Port: 31000},
+		}
+	}
+	// HTTP/3 requests carry the remote address of the QUIC connection.
+	h3 := func(a net.Addr) context.Context {
+		return context.WithValue(context.Background(), http3.RemoteAddrContextKey, a)
+	}
+	// Other requests carry their connection.
+	stream := func(a net.Addr) context.Context {
+		return context.WithValue(context.Background(), caddyhttp.ConnCtxKey, net.Conn(streamConn{remote: a}))
+	}
+	ipAddr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 31000}
+
+	tests := map[string]struct {
+		matcher MatchSCION
+		ctx     context.Context
+		want    bool
+	}{
+		"any scion": {
+			ctx:  h3(scionAddr("1-ff00:0:110", "10.0.0.1")),
+			want: true,
+		},
+		"any scion over a stream": {
+			ctx:  stream(scionAddr("1-ff00:0:110", "10.0.0.1")),
+			want: true,
+		},
+		"isd only": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ISDs: []string{"1"}}},
+			ctx:     h3(scionAddr("1-ff00:0:110", "10.0.0.1")),
+			want:    true,
+		},
+		"isd only mismatch": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ISDs: []string{"2"}}},
+			ctx:     h3(scionAddr("1-ff00:0:110", "10.0.0.1")),
+		},
+		"isd range": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ISDs: []string{"2-4"}}},
+			ctx:     h3(scionAddr("3-ff00:0:110", "10.0.0.1")),
+			want:    true,
+		},
+		"isd and as": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ISDs: []string{"1"}, ASes: []string{"ff00:0:110"}}},
+			ctx:     h3(scionAddr("1-ff00:0:110", "10.0.0.1")),
+			want:    true,
+		},
+		"isd and as mismatch": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ISDs: []string{"1"}, ASes: []string{"ff00:0:110"}}},
+			ctx:     h3(scionAddr("1-ff00:0:111", "10.0.0.1")),
+		},
+		"as of another isd": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ISDs: []string{"1"}, ASes: []string{"ff00:0:110"}}},
+			ctx:     h3(scionAddr("2-ff00:0:110", "10.0.0.1")),
+		},
+		"negated isd": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ISDs: []string{"!1"}}},
+			ctx:     h3(scionAddr("2-ff00:0:110", "10.0.0.1")),
+			want:    true,
+		},
+		"negated isd mismatch": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ISDs: []string{"!1"}}},
+			ctx:     h3(scionAddr("1-ff00:0:110", "10.0.0.1")),
+		},
+		"as range with negation": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ASes: []string{"ff00:0:100-ff00:0:1ff", "!ff00:0:110"}}},
+			ctx:     h3(scionAddr("1-ff00:0:111", "10.0.0.1")),
+			want:    true,
+		},
+		"negated as in range": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ASes: []string{"ff00:0:100-ff00:0:1ff", "!ff00:0:110"}}},
+			ctx:     h3(scionAddr("1-ff00:0:110", "10.0.0.1")),
+		},
+		"host prefix": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{Hosts: []string{"10.0.0.0/8", "!10.0.0.2"}}},
+			ctx:     h3(scionAddr("1-ff00:0:110", "10.0.0.1")),
+			want:    true,
+		},
+		"negated host": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{Hosts: []string{"10.0.0.0/8", "!10.0.0.2"}}},
+			ctx:     h3(scionAddr("1-ff00:0:110", "10.0.0.2")),
+		},
+		"not scion": {
+			ctx: stream(ipAddr),
+		},
+		"not scion with filter": {
+			matcher: MatchSCION{Filter: scionmatch.Filter{ISDs: []string{"!1"}}},
+			ctx:     stream(ipAddr),
+		},
+		"not scion without connection": {
+			ctx: context.Background(),
+		},
+		"ip transport": {
+			matcher: MatchSCION{Transport: TransportIP},
+			ctx:     stream(ipAddr),
+			want:    true,
+		},
+		"ip transport over scion": {
+			matcher: MatchSCION{Transport: TransportIP},
+			ctx:     h3(scionAddr("1-ff00:0:110", "10.0.0.1")),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := tc.matcher
+			if err := m.Provision(caddy.Context{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil).WithContext(tc.ctx)
+			got, err := m.MatchWithError(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/scionproto/scion/private/path/pathpol"
+	"go.uber.org/zap"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/native"
+	"github.com/scionproto-contrib/caddy-scion/reverse/scionrequest"
+)
+
+var (
+	// Interface guards
+	_ caddyhttp.MiddlewareHandler = (*SCIONPathPolicyHandler)(nil)
+	_ caddy.Provisioner           = (*SCIONPathPolicyHandler)(nil)
+	_ caddy.Validator             = (*SCIONPathPolicyHandler)(nil)
+	_ caddy.CleanerUpper          = (*SCIONPathPolicyHandler)(nil)
+	_ caddyfile.Unmarshaler       = (*SCIONPathPolicyHandler)(nil)
+)
+
+const (
+	lookupTimeout = 2 * time.Second
+	// unresolvedTTL is the time an unresolved path is remembered as denied,
+	// e.g., because the daemon did not know it yet.
+	unresolvedTTL = 10 * time.Second
+	// maxCacheEntries is the maximum number of cached results. Above it,
+	// expired entries are pruned, and then the ones expiring first.
+	maxCacheEntries = 1024
+)
+
+func init() {
+	caddy.RegisterModule(SCIONPathPolicyHandler{})
+	httpcaddyfile.RegisterHandlerDirective("scion_path_policy", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("scion_path_policy", httpcaddyfile.Before, "basic_auth")
+}
+
+// SCIONPathPolicyHandler rejects SCION requests whose path crosses ISDs, ASes
+// or interfaces denied by a path-policy ACL. The reply path of the request
+// only carries interface IDs, so it is looked up among the paths known to the
+// SCION daemon of the listener. Paths that cannot be found are denied.
+//
+// Results are cached per path until the path expires. The daemon connections
+// are kept until the handler is cleaned up. Requests that were not received
+// over SCION are passed on.
+type SCIONPathPolicyHandler struct {
+	// ACL is evaluated against every interface of the path, e.g.,
+	// ["- 1-ff00:0:110#0", "+"]. The last entry must match any interface.
+	ACL *pathpol.ACL `json:"acl"`
+	// StatusCode is the status code of denied requests. Defaults to 403.
+	StatusCode caddyhttp.WeakString `json:"status_code,omitempty"`
+
+	logger   *zap.Logger
+	resolver *native.PathResolver
+	paths    func(ctx context.Context, dst, src addr.IA) ([]snet.Path, error)
+
+	cache *verdictCache
+}
+
+type pathKey struct {
+	local, remote addr.IA
+	fingerprint   string
+}
+
+type verdict struct {
+	allowed bool
+	expiry  time.Time
+}
+
+// verdictCache holds the results of the ACL evaluation per path.
+type verdictCache struct {
+	mu      sync.Mutex
+	entries map[pathKey]verdict
+}
+
+func (c *verdictCache) get(key pathKey, now time.Time) (verdict, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries[key]
+	return v, ok && now.Before(v.expiry)
+}
+
+func (c *verdictCache) put(key pathKey, v verdict, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCacheEntries {
+		var (
+			first    pathKey
+			firstExp time.Time
+		)
+		for k, e := range c.entries {
+			if !now.Before(e.expiry) {
+				delete(c.entries, k)
+			} else if firstExp.IsZero() || e.expiry.Before(firstExp) {
+				first, firstExp = k, e.expiry
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			delete(c.entries, first)
+		}
+	}
+	c.entries[key] = v
+}
+
+// CaddyModule returns the Caddy module information.
+func (SCIONPathPolicyHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.scion_path_policy",
+		New: func() caddy.Module { return new(SCIONPathPolicyHandler) },
+	}
+}
+
+func (s *SCIONPathPolicyHandler) Provision(ctx caddy.Context) error {
+	s.logger = ctx.Logger()
+	s.resolver = native.NewPathResolver()
+	s.paths = s.resolver.Paths
+	s.cache = &verdictCache{entries: make(map[pathKey]verdict)}
+	if s.StatusCode == "" {
+		s.StatusCode = caddyhttp.WeakString(strconv.Itoa(http.StatusForbidden))
+	}
+	return nil
+}
+
+func (s *SCIONPathPolicyHandler) Validate() error {
+	if s.ACL == nil {
+		return fmt.Errorf("missing ACL")
+	}
+	if code := s.StatusCode.String(); !strings.Contains(code, "{") {
+		if _, err := strconv.Atoi(code); err != nil {
+			return fmt.Errorf("invalid status code %q: %w", code, err)
+		}
+	}
+	return nil
+}
+
+// Cleanup releases the daemon connections.
+func (s *SCIONPathPolicyHandler) Cleanup() error {
+	if s.resolver == nil {
+		return nil
+	}
+	return s.resolver.Close()
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (s *SCIONPathPolicyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	remote := scionrequest.RemoteAddr(r)
+	if remote == nil {
+		return next.ServeHTTP(w, r)
+	}
+	if s.allowed(r, remote) {
+		return next.ServeHTTP(w, r)
+	}
+
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	codeStr := repl.ReplaceAll(s.StatusCode.String(), "")
+	code, err := strconv.Atoi(codeStr)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	return caddyhttp.Error(code, fmt.Errorf("path from %s denied by path policy", remote.IA))
+}
+
+// allowed evaluates the ACL against the reply path of the request.
+func (s *SCIONPathPolicyHandler) allowed(r *http.Request, remote *snet.UDPAddr) bool {
+	replyPath, ok := scionrequest.DecodePath(remote.Path)
+	if !ok {
+		return false
+	}
+	var local addr.IA
+	if la, ok := r.Context().Value(http.LocalAddrContextKey).(*snet.UDPAddr); ok {
+		local = la.IA
+	}
+	key := pathKey{
+		local:       local,
+		remote:      remote.IA,
+		fingerprint: scionrequest.Fingerprint(replyPath),
+	}
+
+	now := time.Now()
+	if v, ok := s.cache.get(key, now); ok {
+		return v.allowed
+	}
+
+	v := s.evaluate(r.Context(), key)
+	if v.expiry.IsZero() {
+		// Paths within the AS do not expire; they are re-evaluated
+		// periodically nonetheless.
+		v.expiry = now.Add(time.Hour)
+		if exp, ok := scionrequest.Expiry(replyPath); ok {
+			v.expiry = exp
+		}
+	}
+
+	s.cache.put(key, v, now)
+	return v.allowed
+}
+
+// evaluate looks up the path identified by key among the paths known to the
+// daemon, and evaluates the ACL against it. Unresolved paths are denied for
+// unresolvedTTL.
+func (s *SCIONPathPolicyHandler) evaluate(ctx context.Context, key pathKey) verdict {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	paths, err := s.paths(ctx, key.remote, key.local)
+	if err != nil {
+		s.logger.Warn("failed to look up paths",
+			zap.Stringer("remote", key.remote), zap.Error(err))
+		return verdict{expiry: time.Now().Add(unresolvedTTL)}
+	}
+	for _, p := range paths {
+		decoded, ok := scionrequest.DecodePath(p.Dataplane())
+		if !ok || scionrequest.Fingerprint(decoded) != key.fingerprint {
+			continue
+		}
+		allowed := len(s.ACL.Eval([]snet.Path{p})) > 0
+		s.logger.Debug("evaluated path policy",
+			zap.Stringer("remote", key.remote), zap.String("path", fmt.Sprint(p)), zap.Bool("allowed", allowed))
+		return verdict{allowed: allowed}
+	}
+	s.logger.Debug("path of request not known to daemon",
+		zap.Stringer("remote", key.remote), zap.String("fingerprint", key.fingerprint))
+	return verdict{expiry: time.Now().Add(unresolvedTTL)}
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler. Syntax:
+//
+//	scion_path_policy [<status>] {
+//		acl    +|- [<hop predicate>]
+//		status <status>
+//	}
+//
+// The acl subdirective is repeated for every ACL entry, in order.
+func (s *SCIONPathPolicyHandler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		s.StatusCode = caddyhttp.WeakString(d.Val())
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	var entries []*pathpol.ACLEntry
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "acl":
+			args := d.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			var entry pathpol.ACLEntry
+			if err := entry.LoadFromString(strings.Join(args, " ")); err != nil {
+				return d.Errf("parsing ACL entry: %v", err)
+			}
+			entries = append(entries, &entry)
+		case "status":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.StatusCode = caddyhttp.WeakString(d.Val())
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unrecognized subdirective '%s'", d.Val())
+		}
+	}
+	if len(entries) == 0 {
+		return d.Err("missing acl")
+	}
+	acl, err := pathpol.NewACL(entries...)
+	if err != nil {
+		return d.Errf("invalid ACL: %v", err)
+	}
+	s.ACL = acl
+	return nil
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	s := new(SCIONPathPolicyHandler)
+	err := s.UnmarshalCaddyfile(h.Dispenser)
+	return s, err
+}
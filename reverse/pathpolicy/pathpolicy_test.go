@@ -0,0 +1,212 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers/path"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+	"github.com/scionproto/scion/private/path/pathpol"
+	"go.uber.org/zap"
+)
+
+func TestVerdictCacheBounded(t *testing.T) {
+	c := &verdictCache{entries: make(map[pathKey]verdict)}
+	now := time.Now()
+	key := func(i int) pathKey { return pathKey{fingerprint: strconv.Itoa(i)} }
+
+	// None of the entries expire during the test.
+	for i := 0; i < 2*maxCacheEntries; i++ {
+		c.put(key(i), verdict{allowed: true, expiry: now.Add(time.Hour + time.Duration(i))}, now)
+	}
+	if got := len(c.entries); got != maxCacheEntries {
+		t.Fatalf("got %d entries, want %d", got, maxCacheEntries)
+	}
+	// The entries expiring first are evicted first.
+	if _, ok := c.get(key(0), now); ok {
+		t.Error("oldest entry still cached")
+	}
+	if _, ok := c.get(key(2*maxCacheEntries-1), now); !ok {
+		t.Error("newest entry not cached")
+	}
+
+	// Updating a cached entry does not evict another one.
+	c.put(key(2*maxCacheEntries-1), verdict{expiry: now.Add(2 * time.Hour)}, now)
+	if got := len(c.entries); got != maxCacheEntries {
+		t.Fatalf("got %d entries after update, want %d", got, maxCacheEntries)
+	}
+}
+
+// encodedPath returns a path with a hop field per pair of interfaces.
+func encodedPath(t *testing.T, intfs ...[2]uint16) []byte {
+	t.Helper()
+	d := &scion.Decoded{
+		Base: scion.Base{
+			PathMeta: scion.MetaHdr{SegLen: [3]uint8{uint8(len(intfs))}},
+			NumINF:   1,
+			NumHops:  len(intfs),
+		},
+		InfoFields: []path.InfoField{{Timestamp: uint32(time.Now().Unix())}},
+	}
+	for _, intf := range intfs {
+		d.HopFields = append(d.HopFields, path.HopField{
+			ConsIngress: intf[0],
+			ConsEgress:  intf[1],
+			ExpTime:     63,
+		})
+	}
+	b := make([]byte, d.Len())
+	if err := d.SerializeTo(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestServeHTTPFailsClosed(t *testing.T) {
+	local := addr.MustParseIA("1-ff00:0:110")
+	remote := addr.MustParseIA("1-ff00:0:112")
+	transit := addr.MustParseIA("1-ff00:0:111")
+	forbidden := addr.MustParseIA("1-ff00:0:113")
+
+	viaTransit := encodedPath(t, [2]uint16{0, 1}, [2]uint16{2, 3}, [2]uint16{4, 0})
+	viaForbidden := encodedPath(t, [2]uint16{0, 5}, [2]uint16{6, 7}, [2]uint16{8, 0})
+	unknown := encodedPath(t, [2]uint16{0, 9}, [2]uint16{10, 0})
+	daemonPath := func(raw []byte, via addr.IA) snet.Path {
+		return snetpath.Path{
+			Src:           local,
+			Dst:           remote,
+			DataplanePath: snetpath.SCION{Raw: raw},
+			Meta: snet.PathMetadata{Interfaces: []snet.PathInterface{
+				{IA: local, ID: 1}, {IA: via, ID: 2}, {IA: via, ID: 3}, {IA: remote, ID: 4},
+			}},
+		}
+	}
+	known := []snet.Path{daemonPath(viaTransit, transit), daemonPath(viaForbidden, forbidden)}
+
+	var entries []*pathpol.ACLEntry
+	for _, s := range []string{"- 1-ff00:0:113#0", "+"} {
+		var e pathpol.ACLEntry
+		if err := e.LoadFromString(s); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, &e)
+	}
+	acl, err := pathpol.NewACL(entries...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replyPath := func(raw []byte) snet.DataplanePath {
+		var p scion.Raw
+		if err := p.DecodeFromBytes(raw); err != nil {
+			t.Fatal(err)
+		}
+		return snet.RawReplyPath{Path: &p}
+	}
+	host := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 31000}
+	lookupErr := errors.New("daemon unreachable")
+
+	tests := map[string]struct {
+		remote    net.Addr
+		lookupErr error
+		// wantCode is the status code of the denied request, or 0 if the
+		// request is passed on.
+		wantCode int
+	}{
+		"allowed path": {
+			remote: &snet.UDPAddr{IA: remote, Host: host, Path: replyPath(viaTransit)},
+		},
+		"denied path": {
+			remote:   &snet.UDPAddr{IA: remote, Host: host, Path: replyPath(viaForbidden)},
+			wantCode: http.StatusUnavailableForLegalReasons,
+		},
+		"path unknown to the daemon": {
+			remote:   &snet.UDPAddr{IA: remote, Host: host, Path: replyPath(unknown)},
+			wantCode: http.StatusUnavailableForLegalReasons,
+		},
+		"failed lookup": {
+			remote:    &snet.UDPAddr{IA: remote, Host: host, Path: replyPath(viaTransit)},
+			lookupErr: lookupErr,
+			wantCode:  http.StatusUnavailableForLegalReasons,
+		},
+		"undecodable path": {
+			remote:   &snet.UDPAddr{IA: remote, Host: host},
+			wantCode: http.StatusUnavailableForLegalReasons,
+		},
+		"not over SCION": {
+			remote: host,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			lookups := 0
+			s := &SCIONPathPolicyHandler{
+				ACL:        acl,
+				StatusCode: caddyhttp.WeakString(strconv.Itoa(http.StatusUnavailableForLegalReasons)),
+				logger:     zap.NewNop(),
+				paths: func(_ context.Context, dst, src addr.IA) ([]snet.Path, error) {
+					lookups++
+					if dst != remote || src != local {
+						t.Errorf("got lookup from %s to %s, want from %s to %s", src, dst, local, remote)
+					}
+					return known, tc.lookupErr
+				},
+				cache: &verdictCache{entries: make(map[pathKey]verdict)},
+			}
+
+			// The second request is answered from the cache.
+			for i := 0; i < 2; i++ {
+				ctx := context.WithValue(context.Background(), caddy.ReplacerCtxKey, caddy.NewReplacer())
+				ctx = context.WithValue(ctx, http3.RemoteAddrContextKey, tc.remote)
+				ctx = context.WithValue(ctx, http.LocalAddrContextKey,
+					&snet.UDPAddr{IA: local, Host: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 443}})
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil).WithContext(ctx)
+
+				called := false
+				next := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error {
+					called = true
+					return nil
+				})
+				err := s.ServeHTTP(httptest.NewRecorder(), r, next)
+				var herr caddyhttp.HandlerError
+				switch {
+				case tc.wantCode == 0:
+					if err != nil || !called {
+						t.Errorf("got error %v, next called %v, want the request passed on", err, called)
+					}
+				case !errors.As(err, &herr) || herr.StatusCode != tc.wantCode || called:
+					t.Errorf("got error %v, next called %v, want status %d", err, called, tc.wantCode)
+				}
+			}
+			if lookups > 1 {
+				t.Errorf("got %d lookups, want at most 1", lookups)
+			}
+		})
+	}
+}
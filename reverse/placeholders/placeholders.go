@@ -0,0 +1,115 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/scionproto/scion/pkg/snet"
+
+	"github.com/scionproto-contrib/caddy-scion/reverse/scionrequest"
+)
+
+var (
+	// Interface guards
+	_ caddyhttp.MiddlewareHandler = (*SCIONPlaceholdersHandler)(nil)
+	_ caddyfile.Unmarshaler       = (*SCIONPlaceholdersHandler)(nil)
+)
+
+func init() {
+	caddy.RegisterModule(SCIONPlaceholdersHandler{})
+	httpcaddyfile.RegisterHandlerDirective("scion_placeholders", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("scion_placeholders", httpcaddyfile.Before, "map")
+}
+
+// SCIONPlaceholdersHandler sets placeholders describing the SCION peer of the
+// request. It has to run before the handlers that use them:
+//
+//   - {http.request.scion.remote_ia}: ISD-AS of the peer.
+//   - {http.request.scion.remote_host}: host IP of the peer.
+//   - {http.request.scion.path_hops}: number of inter-AS links of the reply path.
+//   - {http.request.scion.path_fingerprint}: hash of the interfaces of the
+//     reply path. It is computed from the dataplane path only, so it does not
+//     match snet.Fingerprint.
+//   - {http.request.scion.path_expiry}: expiry of the reply path (RFC 3339).
+//
+// Requests that were not received over SCION are left untouched; the path
+// placeholders are only set if the reply path is known.
+type SCIONPlaceholdersHandler struct{}
+
+// CaddyModule returns the Caddy module information.
+func (SCIONPlaceholdersHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.scion_placeholders",
+		New: func() caddy.Module { return new(SCIONPlaceholdersHandler) },
+	}
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (SCIONPlaceholdersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if addr := scionrequest.RemoteAddr(r); addr != nil {
+		repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		setPlaceholders(repl, addr)
+	}
+	return next.ServeHTTP(w, r)
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler. The directive takes
+// no arguments:
+//
+//	scion_placeholders
+func (SCIONPlaceholdersHandler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	if d.NextBlock(0) {
+		return d.Errf("unrecognized subdirective '%s'", d.Val())
+	}
+	return nil
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var s SCIONPlaceholdersHandler
+	err := s.UnmarshalCaddyfile(h.Dispenser)
+	return s, err
+}
+
+func setPlaceholders(repl *caddy.Replacer, addr *snet.UDPAddr) {
+	const prefix = "http.request.scion."
+	repl.Set(prefix+"remote_ia", addr.IA.String())
+	if addr.Host != nil {
+		repl.Set(prefix+"remote_host", addr.Host.IP.String())
+	}
+
+	p, ok := scionrequest.DecodePath(addr.Path)
+	if !ok {
+		return
+	}
+	repl.Set(prefix+"path_hops", strconv.Itoa(scionrequest.Hops(p)))
+	if len(p.HopFields) == 0 {
+		return
+	}
+	repl.Set(prefix+"path_fingerprint", scionrequest.Fingerprint(p))
+	if expiry, ok := scionrequest.Expiry(p); ok {
+		repl.Set(prefix+"path_expiry", expiry.UTC().Format(time.RFC3339))
+	}
+}
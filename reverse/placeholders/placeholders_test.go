@@ -0,0 +1,191 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers/path"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+
+	"github.com/scionproto-contrib/caddy-scion/reverse/scionrequest"
+)
+
+const timestamp = 1700000000
+
+// encode serializes a path with a hop field per pair of interfaces. The
+// segments are given by their number of hop fields.
+func encode(t *testing.T, peer bool, segLen []uint8, intfs ...[2]uint16) []byte {
+	t.Helper()
+	d := &scion.Decoded{
+		Base: scion.Base{NumINF: len(segLen), NumHops: len(intfs)},
+	}
+	for i, l := range segLen {
+		d.PathMeta.SegLen[i] = l
+		d.InfoFields = append(d.InfoFields, path.InfoField{
+			Peer:      peer,
+			Timestamp: timestamp + uint32(i),
+		})
+	}
+	for i, intf := range intfs {
+		d.HopFields = append(d.HopFields, path.HopField{
+			ConsIngress: intf[0],
+			ConsEgress:  intf[1],
+			// The last hop field expires first.
+			ExpTime: uint8(63 - i),
+		})
+	}
+	b := make([]byte, d.Len())
+	if err := d.SerializeTo(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// replyPath returns the reply path of a packet received on the raw path.
+func replyPath(t *testing.T, raw []byte) snet.DataplanePath {
+	t.Helper()
+	var p scion.Raw
+	if err := p.DecodeFromBytes(raw); err != nil {
+		t.Fatal(err)
+	}
+	return snet.RawReplyPath{Path: &p}
+}
+
+func fingerprint(t *testing.T, raw []byte) string {
+	t.Helper()
+	var d scion.Decoded
+	if err := d.DecodeFromBytes(raw); err != nil {
+		t.Fatal(err)
+	}
+	return scionrequest.Fingerprint(&d)
+}
+
+func TestServeHTTP(t *testing.T) {
+	const prefix = "http.request.scion."
+	ia := addr.MustParseIA("1-ff00:0:111")
+	host := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}
+
+	core := encode(t, false, []uint8{2, 2}, [2]uint16{0, 1}, [2]uint16{2, 0}, [2]uint16{0, 3}, [2]uint16{4, 0})
+	peering := encode(t, true, []uint8{2, 2}, [2]uint16{0, 1}, [2]uint16{5, 2}, [2]uint16{6, 3}, [2]uint16{4, 0})
+	single := encode(t, false, []uint8{3}, [2]uint16{0, 1}, [2]uint16{2, 3}, [2]uint16{4, 0})
+	// The fourth hop field, which expires after (1+60)/256 days, expires
+	// first.
+	expiry := time.Unix(timestamp+1, 0).Add(61 * 24 * time.Hour / 256).UTC().Format(time.RFC3339)
+
+	tests := map[string]struct {
+		remote net.Addr
+		want   map[string]string
+	}{
+		"reply path": {
+			remote: &snet.UDPAddr{IA: ia, Host: host, Path: replyPath(t, core)},
+			want: map[string]string{
+				"remote_ia":        "1-ff00:0:111",
+				"remote_host":      "192.0.2.1",
+				"path_hops":        "2",
+				"path_fingerprint": fingerprint(t, core),
+				"path_expiry":      expiry,
+			},
+		},
+		"daemon path": {
+			remote: &snet.UDPAddr{IA: ia, Host: host, Path: snetpath.SCION{Raw: core}},
+			want: map[string]string{
+				"remote_ia":        "1-ff00:0:111",
+				"remote_host":      "192.0.2.1",
+				"path_hops":        "2",
+				"path_fingerprint": fingerprint(t, core),
+				"path_expiry":      expiry,
+			},
+		},
+		"peering path": {
+			remote: &snet.UDPAddr{IA: ia, Host: host, Path: replyPath(t, peering)},
+			want: map[string]string{
+				"remote_ia":        "1-ff00:0:111",
+				"remote_host":      "192.0.2.1",
+				"path_hops":        "3",
+				"path_fingerprint": fingerprint(t, peering),
+				"path_expiry":      expiry,
+			},
+		},
+		"single segment": {
+			remote: &snet.UDPAddr{IA: ia, Host: host, Path: replyPath(t, single)},
+			want: map[string]string{
+				"remote_ia":        "1-ff00:0:111",
+				"remote_host":      "192.0.2.1",
+				"path_hops":        "2",
+				"path_fingerprint": fingerprint(t, single),
+				"path_expiry":      time.Unix(timestamp, 0).Add(62 * 24 * time.Hour / 256).UTC().Format(time.RFC3339),
+			},
+		},
+		"within the AS": {
+			remote: &snet.UDPAddr{IA: ia, Host: host, Path: snetpath.Empty{}},
+			want: map[string]string{
+				"remote_ia":   "1-ff00:0:111",
+				"remote_host": "192.0.2.1",
+				"path_hops":   "0",
+			},
+		},
+		"unknown path": {
+			remote: &snet.UDPAddr{IA: ia, Host: host},
+			want: map[string]string{
+				"remote_ia":   "1-ff00:0:111",
+				"remote_host": "192.0.2.1",
+			},
+		},
+		"not over SCION": {
+			remote: host,
+			want:   map[string]string{},
+		},
+	}
+	names := []string{"remote_ia", "remote_host", "path_hops", "path_fingerprint", "path_expiry"}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			repl := caddy.NewReplacer()
+			ctx := context.WithValue(context.Background(), caddy.ReplacerCtxKey, repl)
+			ctx = context.WithValue(ctx, http3.RemoteAddrContextKey, tc.remote)
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil).WithContext(ctx)
+
+			called := false
+			next := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error {
+				called = true
+				return nil
+			})
+			if err := (SCIONPlaceholdersHandler{}).ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+				t.Fatal(err)
+			}
+			if !called {
+				t.Error("next handler not called")
+			}
+			for _, n := range names {
+				got, ok := repl.GetString(prefix + n)
+				want, wantOK := tc.want[n]
+				if ok != wantOK || got != want {
+					t.Errorf("got %s = %q (set %v), want %q (set %v)", n, got, ok, want, wantOK)
+				}
+			}
+		})
+	}
+}
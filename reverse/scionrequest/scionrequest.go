@@ -0,0 +1,125 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scionrequest inspects HTTP requests received over SCION.
+package scionrequest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/scionproto/scion/pkg/slayers/path"
+	"github.com/scionproto/scion/pkg/slayers/path/empty"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+)
+
+// RemoteAddr returns the SCION address of the peer, or nil if the request
+// was not received over SCION. HTTP/3 requests carry the remote address of
+// the QUIC connection; HTTP/1.1 and HTTP/2 requests carry the underlying
+// connection, which is a single stream on top of QUIC.
+func RemoteAddr(r *http.Request) *snet.UDPAddr {
+	var remote net.Addr
+	if a, ok := r.Context().Value(http3.RemoteAddrContextKey).(net.Addr); ok {
+		remote = a
+	} else if c, ok := r.Context().Value(caddyhttp.ConnCtxKey).(net.Conn); ok {
+		remote = c.RemoteAddr()
+	}
+	addr, _ := remote.(*snet.UDPAddr)
+	return addr
+}
+
+// DecodePath decodes p, either the reply path of a received packet or a path
+// returned by the daemon. Paths within the AS are decoded to a path without
+// hop fields. It returns false if p is not a SCION path.
+func DecodePath(p snet.DataplanePath) (*scion.Decoded, bool) {
+	switch p := p.(type) {
+	case snet.RawReplyPath:
+		switch rp := p.Path.(type) {
+		case empty.Path:
+			return &scion.Decoded{}, true
+		case *scion.Decoded:
+			return rp, true
+		case *scion.Raw:
+			d, err := rp.ToDecoded()
+			return d, err == nil
+		}
+	case snetpath.Empty:
+		return &scion.Decoded{}, true
+	case snetpath.SCION:
+		var d scion.Decoded
+		return &d, d.DecodeFromBytes(p.Raw) == nil
+	}
+	return nil, false
+}
+
+// Hops returns the number of inter-AS links of p. Consecutive segments
+// usually meet in an AS that has a hop field in both of them, while segments
+// joined over a peering link have their peering hop fields in the two peer
+// ASes, so the peering link counts as an additional link.
+func Hops(p *scion.Decoded) int {
+	if len(p.HopFields) == 0 {
+		return 0
+	}
+	hops := len(p.HopFields) - len(p.InfoFields)
+	for i := 1; i < len(p.InfoFields); i++ {
+		if p.InfoFields[i-1].Peer && p.InfoFields[i].Peer {
+			hops++
+		}
+	}
+	return hops
+}
+
+// Fingerprint hashes the ingress and egress interfaces of all hop fields of
+// p, in the order of the path. It is computed from the dataplane path only, so
+// it does not match snet.Fingerprint. Reversing a path reverses the order of
+// its hop fields, so a path and its reversal have different fingerprints.
+// Fingerprints are only comparable between paths in the same direction, e.g.,
+// the reply path of a request and the paths returned by the daemon, which both
+// lead from the local to the remote AS.
+func Fingerprint(p *scion.Decoded) string {
+	h := sha256.New()
+	var b [4]byte
+	for _, hf := range p.HopFields {
+		binary.BigEndian.PutUint16(b[:2], hf.ConsIngress)
+		binary.BigEndian.PutUint16(b[2:], hf.ConsEgress)
+		h.Write(b[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Expiry returns the earliest expiration time of the hop fields of p. It
+// returns false if p has no hop fields.
+func Expiry(p *scion.Decoded) (time.Time, bool) {
+	var earliest time.Time
+	hop := 0
+	for i, info := range p.InfoFields {
+		ts := time.Unix(int64(info.Timestamp), 0)
+		for j := 0; j < int(p.PathMeta.SegLen[i]) && hop < len(p.HopFields); j++ {
+			exp := ts.Add(path.ExpTimeToDuration(p.HopFields[hop].ExpTime))
+			if earliest.IsZero() || exp.Before(earliest) {
+				earliest = exp
+			}
+			hop++
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
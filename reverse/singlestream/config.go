@@ -15,33 +15,42 @@
 package singlestream
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/caddyserver/caddy/v2"
-	snetmetrics "github.com/scionproto/scion/pkg/snet/metrics"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 
+	"github.com/scionproto-contrib/caddy-scion/networks/connmetrics"
 	"github.com/scionproto-contrib/caddy-scion/networks/singlestream"
+	"github.com/scionproto-contrib/caddy-scion/reverse/appconfig"
 )
 
 var (
 	// Interface guards
-	_ caddy.Module      = (*SCION)(nil)
-	_ caddy.Provisioner = (*SCION)(nil)
-	_ caddy.App         = (*SCION)(nil)
+	_ caddy.Module          = (*SCION)(nil)
+	_ caddy.Provisioner     = (*SCION)(nil)
+	_ caddy.App             = (*SCION)(nil)
+	_ caddyfile.Unmarshaler = (*SCION)(nil)
 )
 
 var (
-	metrics = snetmetrics.NewSCIONPacketConnMetrics()
+	metrics = connmetrics.NewPacketConnMetrics()
 )
 
 func init() {
 	caddy.RegisterModule(SCION{})
 }
 
-// SCION implements a caddy module. Currently, it is used to initialize the
-// logger for the global network. In the future, additional configuration can be
-// parsed with this component.
+// SCION implements a caddy module. It is used to initialize the logger and
+// the configuration of the global networks. Only the log level applies to the
+// scion+single-stream network; the options of `scion` listeners are
+// rejected, since this flavour is built without them.
 //
 // Has to be configured as Caddy app to be executed.
-type SCION struct{}
+type SCION struct {
+	appconfig.Config
+}
 
 func (SCION) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -53,7 +62,18 @@ func (SCION) CaddyModule() caddy.ModuleInfo {
 }
 
 func (s *SCION) Provision(ctx caddy.Context) error {
-	singlestream.SetLogger(ctx.Logger())
+	if opts := s.NativeOptions(); len(opts) > 0 {
+		return fmt.Errorf("%s only apply to the scion network, which is not built in",
+			strings.Join(opts, ", "))
+	}
+	logger, err := s.Config.Logger(ctx)
+	if err != nil {
+		return err
+	}
+	if err := connmetrics.Register(ctx.GetMetricsRegistry(), metrics.Collectors()...); err != nil {
+		return err
+	}
+	singlestream.SetLogger(logger)
 	singlestream.SetPacketConnMetrics(metrics)
 	return nil
 }
@@ -67,3 +87,8 @@ func (s *SCION) Stop() error {
 	// no-op
 	return nil
 }
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (s *SCION) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	return s.Config.UnmarshalCaddyfile(d)
+}
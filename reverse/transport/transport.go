@@ -0,0 +1,254 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/scionupstream"
+)
+
+var (
+	// Interface guards
+	_ http.RoundTripper         = (*SCIONTransport)(nil)
+	_ caddy.Provisioner         = (*SCIONTransport)(nil)
+	_ caddy.Validator           = (*SCIONTransport)(nil)
+	_ caddy.CleanerUpper        = (*SCIONTransport)(nil)
+	_ caddyfile.Unmarshaler     = (*SCIONTransport)(nil)
+	_ reverseproxy.TLSTransport = (*SCIONTransport)(nil)
+)
+
+const (
+	ProtocolH3           = "h3"
+	ProtocolSingleStream = "single-stream"
+
+	defaultDialTimeout = 5 * time.Second
+)
+
+func init() {
+	caddy.RegisterModule(SCIONTransport{})
+}
+
+// SCIONTransport is a reverse_proxy transport that dials the upstreams over
+// SCION. Upstreams are addressed as [isd-as,ip]:port. Connections are reused
+// across requests to the same upstream.
+type SCIONTransport struct {
+	// Protocol used to reach the upstreams: "h3" (default) for HTTP/3, or
+	// "single-stream" for HTTP/1.1 over a single QUIC stream.
+	Protocol string `json:"protocol,omitempty"`
+
+	// Policy restricting the paths used to reach the upstreams.
+	scionupstream.PathPolicy
+
+	// How long to wait before timing out the QUIC handshake.
+	// Default: 5s
+	DialTimeout caddy.Duration `json:"dial_timeout,omitempty"`
+
+	// Server name used to verify the certificate of HTTP/3 upstreams. The
+	// SCION address of an upstream cannot be verified against its
+	// certificate, so it is required for h3 unless verification is skipped.
+	TLSServerName string `json:"tls_server_name,omitempty"`
+
+	// Whether to skip the verification of the certificate of HTTP/3
+	// upstreams. The single stream is never verified, so neither TLS option
+	// is accepted with it.
+	TLSInsecureSkipVerify bool `json:"tls_insecure_skip_verify,omitempty"`
+
+	policy pan.Policy
+	h3     *http3.Transport
+	h1     *http.Transport
+}
+
+// CaddyModule returns the Caddy module information.
+func (SCIONTransport) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.reverse_proxy.transport.scion",
+		New: func() caddy.Module { return new(SCIONTransport) },
+	}
+}
+
+func (t *SCIONTransport) Provision(ctx caddy.Context) error {
+	if t.Protocol == "" {
+		t.Protocol = ProtocolH3
+	}
+	if t.DialTimeout == 0 {
+		t.DialTimeout = caddy.Duration(defaultDialTimeout)
+	}
+
+	policy, err := t.PathPolicy.Build()
+	if err != nil {
+		return err
+	}
+	t.policy = policy
+
+	switch t.Protocol {
+	case ProtocolH3:
+		t.h3 = &http3.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName:         t.TLSServerName,
+				InsecureSkipVerify: t.TLSInsecureSkipVerify,
+			},
+			Dial: t.dialH3,
+		}
+	case ProtocolSingleStream:
+		t.h1 = &http.Transport{
+			DialContext:     t.dialSingleStream,
+			MaxIdleConns:    100,
+			IdleConnTimeout: 90 * time.Second,
+		}
+	}
+	return nil
+}
+
+func (t *SCIONTransport) Validate() error {
+	switch t.Protocol {
+	case ProtocolH3:
+		if t.TLSServerName == "" && !t.TLSInsecureSkipVerify {
+			return fmt.Errorf("protocol %q requires tls_server_name or tls_insecure_skip_verify", t.Protocol)
+		}
+	case ProtocolSingleStream:
+		if t.TLSServerName != "" || t.TLSInsecureSkipVerify {
+			return fmt.Errorf("protocol %q does not verify upstreams, "+
+				"tls_server_name and tls_insecure_skip_verify are not supported", t.Protocol)
+		}
+	default:
+		return fmt.Errorf("unknown protocol %q", t.Protocol)
+	}
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *SCIONTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.h3 != nil {
+		return t.h3.RoundTrip(req)
+	}
+	return t.h1.RoundTrip(req)
+}
+
+// TLSEnabled implements reverseproxy.TLSTransport. HTTP/3 always uses TLS;
+// the single stream is not secured.
+func (t *SCIONTransport) TLSEnabled() bool {
+	return t.Protocol == ProtocolH3
+}
+
+// EnableTLS implements reverseproxy.TLSTransport. The TLS settings are
+// configured on the transport itself.
+func (t *SCIONTransport) EnableTLS(*reverseproxy.TLSConfig) error {
+	return nil
+}
+
+// Cleanup closes the connections to the upstreams.
+func (t *SCIONTransport) Cleanup() error {
+	if t.h3 != nil {
+		return t.h3.Close()
+	}
+	if t.h1 != nil {
+		t.h1.CloseIdleConnections()
+	}
+	return nil
+}
+
+func (t *SCIONTransport) dialH3(
+	ctx context.Context,
+	address string,
+	tlsCfg *tls.Config,
+	cfg *quic.Config,
+) (*quic.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(t.DialTimeout))
+	defer cancel()
+	return scionupstream.DialQUICEarly(ctx, address, tlsCfg, cfg, t.policy)
+}
+
+func (t *SCIONTransport) dialSingleStream(ctx context.Context, _, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(t.DialTimeout))
+	defer cancel()
+	return scionupstream.DialSingleStream(ctx, address, t.policy)
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler. Syntax:
+//
+//	transport scion {
+//		protocol                 h3|single-stream
+//		acl                      +|- [<hop predicate>]
+//		sequence                 <sequence>
+//		dial_timeout             <duration>
+//		tls_server_name          <name>
+//		tls_insecure_skip_verify
+//	}
+//
+// The acl subdirective is repeated for every ACL entry, in order. The TLS
+// subdirectives only apply to h3, which requires one of them.
+func (t *SCIONTransport) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume transport name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "protocol":
+			if !d.AllArgs(&t.Protocol) {
+				return d.ArgErr()
+			}
+		case "acl":
+			args := d.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			entry := args[0]
+			if len(args) == 2 {
+				entry += " " + args[1]
+			}
+			t.ACL = append(t.ACL, entry)
+		case "sequence":
+			if !d.AllArgs(&t.Sequence) {
+				return d.ArgErr()
+			}
+		case "dial_timeout":
+			var s string
+			if !d.AllArgs(&s) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(s)
+			if err != nil {
+				return d.Errf("parsing dial_timeout: %v", err)
+			}
+			t.DialTimeout = caddy.Duration(dur)
+		case "tls_server_name":
+			if !d.AllArgs(&t.TLSServerName) {
+				return d.ArgErr()
+			}
+		case "tls_insecure_skip_verify":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			t.TLSInsecureSkipVerify = true
+		default:
+			return d.Errf("unrecognized subdirective: %s", d.Val())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reverse
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/scionproto-contrib/caddy-scion/networks/sciontxt"
+)
+
+var (
+	// Interface guards
+	_ caddy.Provisioner           = (*SCIONTXTUpstreams)(nil)
+	_ reverseproxy.UpstreamSource = (*SCIONTXTUpstreams)(nil)
+	_ caddyfile.Unmarshaler       = (*SCIONTXTUpstreams)(nil)
+)
+
+const (
+	// minTTL bounds how often a name is looked up, regardless of the TTL of
+	// its records.
+	minTTL = time.Second
+	// maxCached is the number of names whose lookups are cached.
+	maxCached = 100
+)
+
+func init() {
+	caddy.RegisterModule(SCIONTXTUpstreams{})
+}
+
+// SCIONTXTUpstreams provides upstreams from the SCION addresses published in
+// DNS TXT records of the form "scion=<isd-as>,<ip>". If a name has no such
+// record, its A/AAAA records are used instead, unless disable_ip_fallback is
+// set. These upstreams are IP addresses, which the SCION transport cannot
+// dial; disable the fallback when the upstreams are only reachable over
+// SCION. Results are cached for the TTL of the records.
+type SCIONTXTUpstreams struct {
+	// The domain name to look up.
+	Name string `json:"name,omitempty"`
+
+	// The port to use with the upstreams. Default: 443
+	Port string `json:"port,omitempty"`
+
+	// Configures the DNS servers used to resolve the domain name, e.g., a
+	// local stub server in tests. By default, the nameservers in
+	// /etc/resolv.conf are used.
+	Resolver *reverseproxy.UpstreamResolver `json:"resolver,omitempty"`
+
+	// How long to wait for a DNS server to answer. Default: 5s
+	DialTimeout caddy.Duration `json:"dial_timeout,omitempty"`
+
+	// Whether to fail the lookup of names without SCION TXT records instead
+	// of falling back to their A/AAAA records.
+	DisableIPFallback bool `json:"disable_ip_fallback,omitempty"`
+
+	lookup lookupFunc
+	cache  *lookupCache
+	logger *zap.Logger
+}
+
+// lookupFunc resolves a name.
+type lookupFunc func(ctx context.Context, name string) (sciontxt.Result, error)
+
+// CaddyModule returns the Caddy module information.
+func (SCIONTXTUpstreams) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.reverse_proxy.upstreams.scion_txt",
+		New: func() caddy.Module { return new(SCIONTXTUpstreams) },
+	}
+}
+
+func (su *SCIONTXTUpstreams) Provision(ctx caddy.Context) error {
+	su.logger = ctx.Logger()
+	if su.Port == "" {
+		su.Port = "443"
+	}
+	su.cache = &lookupCache{entries: make(map[string]cachedLookup)}
+
+	resolver := &sciontxt.Resolver{
+		Timeout:      time.Duration(su.DialTimeout),
+		NoIPFallback: su.DisableIPFallback,
+	}
+	if su.Resolver != nil {
+		for _, v := range su.Resolver.Addresses {
+			a, err := caddy.ParseNetworkAddressWithDefaults(v, "udp", 53)
+			if err != nil {
+				return err
+			}
+			if a.PortRangeSize() != 1 {
+				return fmt.Errorf("resolver address must have exactly one address; cannot call %v", a)
+			}
+			resolver.Servers = append(resolver.Servers, a.JoinHostPort(0))
+		}
+	}
+	su.lookup = resolver.Lookup
+	return nil
+}
+
+func (su *SCIONTXTUpstreams) GetUpstreams(r *http.Request) ([]*reverseproxy.Upstream, error) {
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	name := repl.ReplaceAll(su.Name, "")
+	port := repl.ReplaceAll(su.Port, "")
+
+	return su.cache.get(net.JoinHostPort(name, port), func() ([]string, time.Duration, error) {
+		if c := su.logger.Check(zapcore.DebugLevel, "refreshing SCION TXT upstreams"); c != nil {
+			c.Write(zap.String("name", name), zap.String("port", port))
+		}
+		// The lookup is shared with concurrent requests for the same name,
+		// so it must not be canceled when this request is.
+		res, err := su.lookup(context.WithoutCancel(r.Context()), name)
+		if err != nil {
+			return nil, 0, err
+		}
+		dials := make([]string, 0, len(res.Hosts))
+		for _, h := range res.Hosts {
+			dial := h.JoinHostPort(port)
+			if c := su.logger.Check(zapcore.DebugLevel, "discovered upstream"); c != nil {
+				c.Write(zap.String("dial", dial), zap.Bool("scion", !h.IA.IsZero()))
+			}
+			dials = append(dials, dial)
+		}
+		return dials, max(res.TTL, minTTL), nil
+	})
+}
+
+func (su SCIONTXTUpstreams) String() string { return net.JoinHostPort(su.Name, su.Port) }
+
+// UnmarshalCaddyfile deserializes Caddyfile tokens into su. Syntax:
+//
+//	dynamic scion_txt [<name> [<port>]] {
+//		name         <name>
+//		port         <port>
+//		resolvers    <resolvers...>
+//		dial_timeout <timeout>
+//		disable_ip_fallback
+//	}
+func (su *SCIONTXTUpstreams) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume upstream source name
+	if d.NextArg() {
+		su.Name = d.Val()
+	}
+	if d.NextArg() {
+		su.Port = d.Val()
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "name":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			su.Name = d.Val()
+		case "port":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			su.Port = d.Val()
+		case "resolvers":
+			if su.Resolver == nil {
+				su.Resolver = new(reverseproxy.UpstreamResolver)
+			}
+			su.Resolver.Addresses = append(su.Resolver.Addresses, d.RemainingArgs()...)
+			if len(su.Resolver.Addresses) == 0 {
+				return d.Errf("must specify at least one resolver address")
+			}
+		case "dial_timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("bad timeout value '%s': %v", d.Val(), err)
+			}
+			su.DialTimeout = caddy.Duration(dur)
+		case "disable_ip_fallback":
+			su.DisableIPFallback = true
+		default:
+			return d.Errf("unrecognized subdirective: %s", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// lookupCache caches the dial addresses per name and port until the TTL of
+// the records expires. Concurrent refreshes of the same key share a single
+// lookup.
+type lookupCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedLookup
+	group   singleflight.Group
+}
+
+type cachedLookup struct {
+	dials   []string
+	expires time.Time
+}
+
+func (c *lookupCache) get(
+	key string,
+	refresh func() ([]string, time.Duration, error),
+) ([]*reverseproxy.Upstream, error) {
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if !ok || time.Now().After(cached.expires) {
+		v, err, _ := c.group.Do(key, func() (any, error) {
+			dials, ttl, err := refresh()
+			if err != nil {
+				return nil, err
+			}
+			cached := cachedLookup{dials: dials, expires: time.Now().Add(ttl)}
+			c.put(key, cached)
+			return cached, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		cached = v.(cachedLookup)
+	}
+
+	upstreams := make([]*reverseproxy.Upstream, len(cached.dials))
+	for i, dial := range cached.dials {
+		upstreams[i] = &reverseproxy.Upstream{Dial: dial}
+	}
+	return upstreams, nil
+}
+
+func (c *lookupCache) put(key string, cached cachedLookup) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCached {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = cached
+}